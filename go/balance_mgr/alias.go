@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// INDEX_ALIAS - index of alias to account. Lookups scan the alias prefix, so that a concurrent
+	// transaction taking the same alias fails the phantom read check at commit.
+	INDEX_ALIAS = "alias~account"
+)
+
+// AccountAlias - account an alias resolves to, also payload of alias events
+type AccountAlias struct {
+	Alias   string `json:"alias"`
+	Account string `json:"account"`
+	From    string `json:"from,omitempty"`
+}
+
+// hasAlias - check whether alias belongs to account
+func (t *Account) hasAlias(alias string) bool {
+	idx := sort.SearchStrings(t.Aliases, alias)
+	return idx < len(t.Aliases) && t.Aliases[idx] == alias
+}
+
+// addAlias - add alias to account keeping aliases sorted
+func (t *Account) addAlias(alias string) {
+	if t.hasAlias(alias) {
+		return
+	}
+	t.Aliases = append(t.Aliases, alias)
+	sort.Strings(t.Aliases)
+}
+
+// removeAlias - remove alias from account
+func (t *Account) removeAlias(alias string) {
+	aliases := make([]string, 0, len(t.Aliases))
+	for _, val := range t.Aliases {
+		if val != alias {
+			aliases = append(aliases, val)
+		}
+	}
+	if len(aliases) == 0 {
+		aliases = nil
+	}
+	t.Aliases = aliases
+}
+
+// lookupAlias - name of account holding alias, empty if alias is free
+func lookupAlias(stub shim.ChaincodeStubInterface, alias string) (string, error) {
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_ALIAS, []string{alias})
+	if err != nil {
+		return "", err
+	}
+	defer resultIt.Close()
+
+	if !resultIt.HasNext() {
+		return "", nil
+	}
+	kv, err := resultIt.Next()
+	if err != nil {
+		return "", err
+	}
+	_, attrs, err := stub.SplitCompositeKey(kv.Key)
+	if err != nil {
+		return "", err
+	}
+	if len(attrs) != 2 {
+		return "", fmt.Errorf(`invalid alias index key. (key: "%s")`, kv.Key)
+	}
+	return attrs[1], nil
+}
+
+// indexAlias - point alias to account
+func indexAlias(stub shim.ChaincodeStubInterface, alias string, accountName string) error {
+	key, err := stub.CreateCompositeKey(INDEX_ALIAS, []string{alias, accountName})
+	if err != nil {
+		return err
+	}
+	// an empty value would delete the key
+	return stub.PutState(key, []byte{0x00})
+}
+
+// unindexAlias - remove alias pointing to account
+func unindexAlias(stub shim.ChaincodeStubInterface, alias string, accountName string) error {
+	key, err := stub.CreateCompositeKey(INDEX_ALIAS, []string{alias, accountName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// releaseAliases - free every alias of account, so that other accounts can take them
+func releaseAliases(stub shim.ChaincodeStubInterface, account *Account) error {
+	for _, alias := range account.Aliases {
+		err := unindexAlias(stub, alias, account.Name)
+		if err != nil {
+			return err
+		}
+	}
+	account.Aliases = nil
+	return nil
+}
+
+// loadAliasAccount - account holding alias, the error response is returned if alias is free
+func loadAliasAccount(stub shim.ChaincodeStubInterface, alias string) (*Account, pb.Response) {
+	accountName, err := lookupAlias(stub, alias)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if accountName == "" {
+		return nil, errorResponse(ERR_ALIAS_NOT_FOUND, fmt.Sprintf(`Alias not found. (alias: "%s")`, alias))
+	}
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return nil, errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	return account, shim.Success(nil)
+}
+
+// authorizeAlias - check that the creator may change aliases of account (owner or admin), and that
+// the account is active
+func authorizeAlias(stub shim.ChaincodeStubInterface, account *Account) (pb.Response, bool) {
+	if !isAdmin(stub) {
+		err := authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error()), false
+		}
+	}
+	if resp, inactive := notActive(account); inactive {
+		return resp, false
+	}
+	return shim.Success(nil), true
+}
+
+// setAlias: add a unique alias to account (owner or admin only), <account> <alias>.
+// Aliases such as phone numbers or email addresses should be hashed by the client.
+func (t *BalanceManager) setAlias(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	accountName := args[0]
+	alias := args[1]
+	if alias == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Alias must not be empty.")
+	}
+
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if resp, ok := authorizeAlias(stub, account); !ok {
+		return resp
+	}
+
+	holder, err := lookupAlias(stub, alias)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid alias. (alias: "%s", cause: %s)`, alias, err))
+	}
+	if holder != "" {
+		return errorResponse(ERR_ALIAS_EXISTS, fmt.Sprintf(`Alias already taken. (alias: "%s", Account: "%s")`, alias, holder))
+	}
+
+	err = indexAlias(stub, alias, accountName)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid alias. (alias: "%s", cause: %s)`, alias, err))
+	}
+	account.addAlias(alias)
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ALIAS_SET, AccountAlias{Alias: alias, Account: accountName})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// removeAlias: free alias of an account (owner or admin only)
+func (t *BalanceManager) removeAlias(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	alias := args[0]
+	account, resp := loadAliasAccount(stub, alias)
+	if account == nil {
+		return resp
+	}
+	if resp, ok := authorizeAlias(stub, account); !ok {
+		return resp
+	}
+
+	err := unindexAlias(stub, alias, account.Name)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	account.removeAlias(alias)
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ALIAS_REMOVED, AccountAlias{Alias: alias, Account: account.Name})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// transferAlias: move alias to another active account (owner of the current account or admin only),
+// <alias> <account>
+func (t *BalanceManager) transferAlias(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	alias := args[0]
+	accountName := args[1]
+
+	from, resp := loadAliasAccount(stub, alias)
+	if from == nil {
+		return resp
+	}
+	if resp, ok := authorizeAlias(stub, from); !ok {
+		return resp
+	}
+	if from.Name == accountName {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Alias already belongs to account. (alias: "%s", Account: "%s")`, alias, accountName))
+	}
+
+	to, err := getAccount(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if to == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if resp, inactive := notActive(to); inactive {
+		return resp
+	}
+
+	err = unindexAlias(stub, alias, from.Name)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = indexAlias(stub, alias, to.Name)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	from.removeAlias(alias)
+	to.addAlias(alias)
+	for _, account := range []*Account{from, to} {
+		err = putAccount(stub, account)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+
+	err = emitEvent(stub, EVENT_ALIAS_TRANSFERRED, AccountAlias{Alias: alias, Account: to.Name, From: from.Name})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// resolveAlias: query account an alias belongs to
+func (t *BalanceManager) resolveAlias(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	accountName, err := lookupAlias(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid alias. (alias: "%s", cause: %s)`, args[0], err))
+	}
+	if accountName == "" {
+		return errorResponse(ERR_ALIAS_NOT_FOUND, fmt.Sprintf(`Alias not found. (alias: "%s")`, args[0]))
+	}
+
+	bytes, err := json.Marshal(AccountAlias{Alias: args[0], Account: accountName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AccountAliases(t *testing.T) {
+	account := NewAccount("a", Identity{MSPID: "Org1MSP", ID: "user1"})
+	assert.False(t, account.hasAlias("x"))
+
+	account.addAlias("tel:2")
+	account.addAlias("mail:1")
+	account.addAlias("tel:2")
+	assert.Equal(t, []string{"mail:1", "tel:2"}, account.Aliases)
+	assert.True(t, account.hasAlias("tel:2"))
+
+	account.removeAlias("mail:1")
+	assert.Equal(t, []string{"tel:2"}, account.Aliases)
+	account.removeAlias("tel:2")
+	assert.Nil(t, account.Aliases)
+
+	// accounts without aliases keep the stored layout
+	bytes, err := json.Marshal(account)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bytes), "aliases")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_ALLOWANCE = "owner~spender~asset"
+)
+
+// Allowance - remaining amount of an asset the spender may transfer from accounts of the owner,
+// stored under composite key 'owner~spender~asset'. An empty expiry never expires.
+type Allowance struct {
+	AbstractDoc
+	Owner     Identity `json:"owner"`
+	Spender   Identity `json:"spender"`
+	Asset     string   `json:"asset"`
+	Amount    Amount   `json:"amount"`
+	Expiry    string   `json:"expiry,omitempty"`
+	UpdatedTx string   `json:"updated_tx"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// ParseAllowance - parse allowance document
+func ParseAllowance(data []byte) (*Allowance, error) {
+	allowance := Allowance{}
+	err := json.Unmarshal(data, &allowance)
+	if err != nil || allowance.DocType != DOC_ALLOWANCE {
+		return nil, fmt.Errorf(`invalid allowance document. (value: "%s")`, string(data))
+	}
+	if allowance.Version > ALLOWANCE_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported allowance document version. (expecting <= %d, actual: %d)`, ALLOWANCE_DOC_VERSION, allowance.Version)
+	}
+	return &allowance, nil
+}
+
+// expired - check whether allowance is expired at time
+func (t *Allowance) expired(now time.Time) bool {
+	return t.Expiry != "" && t.Expiry <= now.UTC().Format(TIMESTAMP_FORMAT)
+}
+
+func allowanceKey(stub shim.ChaincodeStubInterface, owner Identity, spender Identity, assetCode string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_ALLOWANCE, []string{owner.MSPID, owner.ID, spender.MSPID, spender.ID, assetCode})
+}
+
+// getAllowance - load allowance, nil if never approved or revoked
+func getAllowance(stub shim.ChaincodeStubInterface, owner Identity, spender Identity, assetCode string) (*Allowance, error) {
+	key, err := allowanceKey(stub, owner, spender, assetCode)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseAllowance(valBytes)
+}
+
+// putAllowance - stamp allowance with current transaction and write it to ledger
+func putAllowance(stub shim.ChaincodeStubInterface, allowance *Allowance) error {
+	key, err := allowanceKey(stub, allowance.Owner, allowance.Spender, allowance.Asset)
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	allowance.UpdatedTx = stub.GetTxID()
+	allowance.UpdatedAt = timestamp
+
+	bytes, err := json.Marshal(allowance)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// spendAllowance - take amount from the allowance the owner of account granted to the creator,
+// the error response is returned if missing, expired or exceeded
+func spendAllowance(stub shim.ChaincodeStubInterface, account *Account, asset *Asset, amount Amount) (*Allowance, pb.Response) {
+	spender, err := creatorIdentity(stub)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account.Owner.ID == "" {
+		return nil, errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`Account has no owner to grant allowance. (Account: "%s")`, account.Name))
+	}
+	allowance, err := getAllowance(stub, account.Owner, spender, asset.Code)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if allowance == nil {
+		return nil, errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`No allowance granted by owner of account. (Account: "%s", asset: "%s")`, account.Name, asset.Code))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if allowance.expired(now) {
+		return nil, errorResponse(ERR_ALLOWANCE_EXPIRED, fmt.Sprintf(`Allowance expired. (Account: "%s", asset: "%s", expiry: "%s")`, account.Name, asset.Code, allowance.Expiry))
+	}
+	if allowance.Amount.Cmp(amount) < 0 {
+		return nil, errorResponse(ERR_ALLOWANCE_EXCEEDED, fmt.Sprintf(`Allowance exceeded. (Account: "%s", asset: "%s", allowance: %s, amount: %s)`, account.Name, asset.Code, allowance.Amount, amount))
+	}
+
+	allowance.Amount = allowance.Amount.Sub(amount)
+	err = putAllowance(stub, allowance)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	return allowance, shim.Success(nil)
+}
+
+// approve: allow spender to transfer up to amount of asset from accounts owned by the creator until
+// the optional expiry, replacing the previous allowance
+func (t *BalanceManager) approve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 && len(args) != 5 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 4 or 5")
+	}
+
+	spender := Identity{ID: args[0], MSPID: args[1]}
+	if spender.ID == "" || spender.MSPID == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Spender id and MSP id must not be empty.")
+	}
+	owner, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if owner.Equals(spender) {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Approving the owner itself is not allowed.")
+	}
+
+	asset, resp := loadAsset(stub, args[2])
+	if asset == nil {
+		return resp
+	}
+	amount, err := ParseAmount(args[3], asset.Decimals)
+	if err != nil || amount.Sign() < 0 {
+		return errorResponse(ERR_INVALID_AMOUNT, fmt.Sprintf(`Invalid allowance, expecting a non-negative value with at most %d decimals. (actual: "%s")`, asset.Decimals, args[3]))
+	}
+
+	expiry := ""
+	if val := optionalArg(args, 4); val != "" {
+		now, err := txTime(stub)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		tm, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil || !tm.After(now) {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid expiry, expecting a future time in RFC3339 format. (actual: "%s")`, val))
+		}
+		expiry = tm.UTC().Format(TIMESTAMP_FORMAT)
+	}
+
+	allowance := Allowance{Owner: owner, Spender: spender, Asset: asset.Code, Amount: amount, Expiry: expiry}
+	allowance.DocType = DOC_ALLOWANCE
+	allowance.Version = ALLOWANCE_DOC_VERSION
+	err = putAllowance(stub, &allowance)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ALLOWANCE_APPROVED, AllowanceEvent{Owner: owner, Spender: spender, Asset: asset.Code, Amount: &amount, Expiry: expiry})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// revoke: remove allowance the creator granted to spender
+func (t *BalanceManager) revoke(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	owner, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	spender := Identity{ID: args[0], MSPID: args[1]}
+	allowance, err := getAllowance(stub, owner, spender, args[2])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if allowance == nil {
+		return errorResponse(ERR_ALLOWANCE_NOT_FOUND, fmt.Sprintf(`Allowance not found. (spender: "%s", MSP: "%s", asset: "%s")`, spender.ID, spender.MSPID, args[2]))
+	}
+
+	key, err := allowanceKey(stub, owner, spender, args[2])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ALLOWANCE_REVOKED, AllowanceEvent{Owner: owner, Spender: spender, Asset: args[2]})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryAllowance: query allowance of owner to spender, <ownerID> <ownerMSP> <spenderID> <spenderMSP> <asset>
+func (t *BalanceManager) queryAllowance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 5")
+	}
+
+	owner := Identity{ID: args[0], MSPID: args[1]}
+	spender := Identity{ID: args[2], MSPID: args[3]}
+	allowance, err := getAllowance(stub, owner, spender, args[4])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if allowance == nil {
+		return errorResponse(ERR_ALLOWANCE_NOT_FOUND, fmt.Sprintf(`Allowance not found. (spender: "%s", MSP: "%s", asset: "%s")`, spender.ID, spender.MSPID, args[4]))
+	}
+
+	bytes, err := json.Marshal(allowance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// transferFrom: transfer from account on behalf of its owner, the creator spends the allowance
+// granted by the owner. Fees are paid from the account like transfer, outside of the allowance.
+func (t *BalanceManager) transferFrom(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("transfer account on behalf of owner")
+	return t.transferFunds(stub, args, true)
+}
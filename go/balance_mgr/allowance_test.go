@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseAllowance(t *testing.T) {
+	data := `{"doc_type":"ALLOWANCE","version":1,"owner":{"id":"o","msp_id":"Org1MSP"},"spender":{"id":"s","msp_id":"Org2MSP"},"asset":"PTS","amount":"7.50"}`
+	allowance, err := ParseAllowance([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "7.50", allowance.Amount.String())
+	assert.True(t, allowance.Spender.Equals(Identity{ID: "s", MSPID: "Org2MSP"}))
+	assert.False(t, allowance.expired(time.Now()), "never expires without expiry.")
+
+	allowance.Expiry = "2020-01-02T00:00:00.000000000Z"
+	assert.False(t, allowance.expired(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, allowance.expired(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+}
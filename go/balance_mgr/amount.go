@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Amount - fixed-point decimal backed by big integer, value = units / 10^scale
+type Amount struct {
+	units *big.Int
+	scale int
+}
+
+// ZeroAmount - amount of 0 with scale
+func ZeroAmount(scale int) Amount {
+	return Amount{units: new(big.Int), scale: scale}
+}
+
+// AmountFromInt - amount of an integer value, scale 0
+func AmountFromInt(val int64) Amount {
+	return Amount{units: big.NewInt(val), scale: 0}
+}
+
+// ParseAmount - parse decimal text such as '-12.5', at most scale fractional digits are accepted.
+// The returned amount always carries the given scale.
+func ParseAmount(val string, scale int) (Amount, error) {
+	amount, err := parseDecimal(val)
+	if err != nil {
+		return Amount{}, err
+	}
+	if amount.scale > scale {
+		return Amount{}, fmt.Errorf(`too many fractional digits. (expecting <= %d, actual: "%s")`, scale, val)
+	}
+	return amount.Rescale(scale)
+}
+
+// ParseCanonicalAmount - parse amount formatted by String, any other format is rejected
+func ParseCanonicalAmount(val string) (Amount, error) {
+	amount, err := parseDecimal(val)
+	if err != nil {
+		return Amount{}, err
+	}
+	if amount.String() != val {
+		return Amount{}, fmt.Errorf(`amount is not in canonical format. (actual: "%s", expecting: "%s")`, val, amount.String())
+	}
+	return amount, nil
+}
+
+func parseDecimal(val string) (Amount, error) {
+	digits := strings.TrimPrefix(val, "-")
+	intPart, fracPart := digits, ""
+	if idx := strings.Index(digits, "."); idx >= 0 {
+		intPart, fracPart = digits[:idx], digits[idx+1:]
+		if fracPart == "" {
+			return Amount{}, fmt.Errorf(`invalid decimal amount. (actual: "%s")`, val)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, fmt.Errorf(`invalid decimal amount. (actual: "%s")`, val)
+	}
+
+	units, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf(`invalid decimal amount. (actual: "%s")`, val)
+	}
+	if strings.HasPrefix(val, "-") {
+		units.Neg(units)
+	}
+	return Amount{units: units, scale: len(fracPart)}, nil
+}
+
+func isDigits(val string) bool {
+	for _, c := range val {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Amount) value() *big.Int {
+	if t.units == nil {
+		return new(big.Int)
+	}
+	return t.units
+}
+
+// Scale - number of fractional digits
+func (t Amount) Scale() int {
+	return t.scale
+}
+
+// Rescale - same value with another scale, error if fractional digits would be lost
+func (t Amount) Rescale(scale int) (Amount, error) {
+	if scale < 0 {
+		return Amount{}, fmt.Errorf("negative scale. (actual: %d)", scale)
+	}
+	if scale >= t.scale {
+		factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-t.scale)), nil)
+		return Amount{units: new(big.Int).Mul(t.value(), factor), scale: scale}, nil
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(t.scale-scale)), nil)
+	quo, rem := new(big.Int).QuoRem(t.value(), factor, new(big.Int))
+	if rem.Sign() != 0 {
+		return Amount{}, fmt.Errorf(`amount can not be represented with %d fractional digits. (actual: "%s")`, scale, t.String())
+	}
+	return Amount{units: quo, scale: scale}, nil
+}
+
+// align - both amounts with the larger scale of the two
+func align(a, b Amount) (*big.Int, *big.Int, int) {
+	scale := a.scale
+	if b.scale > scale {
+		scale = b.scale
+	}
+	x, _ := a.Rescale(scale)
+	y, _ := b.Rescale(scale)
+	return x.units, y.units, scale
+}
+
+// Add - t + other
+func (t Amount) Add(other Amount) Amount {
+	x, y, scale := align(t, other)
+	return Amount{units: new(big.Int).Add(x, y), scale: scale}
+}
+
+// Sub - t - other
+func (t Amount) Sub(other Amount) Amount {
+	x, y, scale := align(t, other)
+	return Amount{units: new(big.Int).Sub(x, y), scale: scale}
+}
+
+// Neg - -t
+func (t Amount) Neg() Amount {
+	return Amount{units: new(big.Int).Neg(t.value()), scale: t.scale}
+}
+
+// Percent - rate percent of t with scale fractional digits, rounded half away from zero
+func (t Amount) Percent(rate Amount, scale int) Amount {
+	// t * rate / 100 carries t.scale + rate.scale + 2 fractional digits
+	units := new(big.Int).Mul(t.value(), rate.value())
+	exact := t.scale + rate.scale + 2
+	if exact <= scale {
+		amount, _ := Amount{units: units, scale: exact}.Rescale(scale)
+		return amount
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exact-scale)), nil)
+	quo, rem := new(big.Int).QuoRem(units, factor, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(factor) >= 0 {
+		quo.Add(quo, big.NewInt(int64(units.Sign())))
+	}
+	return Amount{units: quo, scale: scale}
+}
+
+// Cmp - -1 if t < other, 0 if t == other, +1 if t > other
+func (t Amount) Cmp(other Amount) int {
+	x, y, _ := align(t, other)
+	return x.Cmp(y)
+}
+
+// Sign - -1 if t < 0, 0 if t == 0, +1 if t > 0
+func (t Amount) Sign() int {
+	return t.value().Sign()
+}
+
+// String - canonical format, exactly scale fractional digits, e.g. '-12.50' for scale 2
+func (t Amount) String() string {
+	digits := new(big.Int).Abs(t.value()).String()
+	if t.scale > 0 {
+		if len(digits) <= t.scale {
+			digits = strings.Repeat("0", t.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-t.scale] + "." + digits[len(digits)-t.scale:]
+	}
+	if t.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalJSON - amount is serialized as canonical string
+func (t Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON - only canonical string is accepted, so corrupted values fail loudly
+func (t *Amount) UnmarshalJSON(data []byte) error {
+	var val string
+	err := json.Unmarshal(data, &val)
+	if err != nil {
+		return fmt.Errorf(`amount must be a string. (actual: %s)`, string(data))
+	}
+	amount, err := ParseCanonicalAmount(val)
+	if err != nil {
+		return err
+	}
+	*t = amount
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseAmount(t *testing.T) {
+	amount, err := ParseAmount("12.5", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "12.50", amount.String())
+
+	amount, err = ParseAmount("-0.05", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "-0.05", amount.String())
+
+	amount, err = ParseAmount("123456789012345678901234567890", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012345678901234567890", amount.String())
+
+	_, err = ParseAmount("1.234", 2)
+	assert.NotNil(t, err, "more fractional digits than scale.")
+
+	for _, val := range []string{"", "-", "1.", ".5", "1e3", "+1", "1,000", " 1"} {
+		_, err = ParseAmount(val, 2)
+		assert.NotNil(t, err, val)
+	}
+}
+
+func Test_AmountArithmetic(t *testing.T) {
+	a, _ := ParseAmount("10.00", 2)
+	b, _ := ParseAmount("0.5", 1)
+
+	assert.Equal(t, "10.50", a.Add(b).String())
+	assert.Equal(t, "9.50", a.Sub(b).String())
+	assert.Equal(t, "-9.50", b.Sub(a).String())
+	assert.Equal(t, 1, a.Cmp(b))
+	assert.Equal(t, 0, a.Cmp(AmountFromInt(10)))
+
+	_, err := a.Add(b).Rescale(0)
+	assert.NotNil(t, err, "fractional digits would be lost.")
+	c, err := AmountFromInt(3).Rescale(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.00", c.String())
+}
+
+func Test_AmountJSON(t *testing.T) {
+	a, _ := ParseAmount("7.25", 2)
+	bytes, err := json.Marshal(a)
+	assert.Nil(t, err)
+	assert.Equal(t, `"7.25"`, string(bytes))
+
+	b := Amount{}
+	assert.Nil(t, json.Unmarshal(bytes, &b))
+	assert.Equal(t, 0, a.Cmp(b))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`7.25`), &b), "number is not accepted.")
+	assert.NotNil(t, json.Unmarshal([]byte(`"07.25"`), &b), "non-canonical string is not accepted.")
+	assert.NotNil(t, json.Unmarshal([]byte(`"abc"`), &b), "corrupted value is not accepted.")
+}
+
+func Test_AmountPercent(t *testing.T) {
+	a, _ := ParseAmount("1234.56", 2)
+	rate, _ := ParseAmount("0.5", 1)
+	assert.Equal(t, "6.17", a.Percent(rate, 2).String(), "6.1728 rounded.")
+
+	b, _ := ParseAmount("1.00", 2)
+	assert.Equal(t, "0.01", b.Percent(rate, 2).String(), "0.005 rounded half up.")
+	assert.Equal(t, "-0.01", b.Neg().Percent(rate, 2).String(), "rounded away from zero.")
+	assert.Equal(t, "0.00500", b.Percent(rate, 5).String())
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	ATTR_ADMIN  = "admin"
+	ATTR_MINTER = "minter"
+
+	// CONFIG_ROLES - config entry of the MSPs trusted to issue the admin and minter attributes
+	CONFIG_ROLES = "roles"
+)
+
+// RoleConfig - MSPs whose admin and minter attributes are honored. Any org of the channel can issue
+// attributes to its members, so an attribute counts only if the creator belongs to a listed MSP.
+type RoleConfig struct {
+	AbstractDoc
+	AdminMSPs  []string `json:"admin_msps"`
+	MinterMSPs []string `json:"minter_msps"`
+	UpdatedTx  string   `json:"updated_tx"`
+	UpdatedAt  string   `json:"updated_at"`
+}
+
+// Normalize - validate role config, at least one admin MSP is required so that it can be changed again
+func (t *RoleConfig) Normalize() error {
+	if len(t.AdminMSPs) == 0 {
+		return fmt.Errorf("admin MSPs required")
+	}
+	for _, list := range [][]string{t.AdminMSPs, t.MinterMSPs} {
+		for _, mspID := range list {
+			if mspID == "" {
+				return fmt.Errorf("MSP id must not be empty")
+			}
+		}
+	}
+	if t.MinterMSPs == nil {
+		t.MinterMSPs = []string{}
+	}
+	return nil
+}
+
+// trusts - check whether MSP is listed
+func trusts(mspIDs []string, mspID string) bool {
+	for _, val := range mspIDs {
+		if val == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// getRoleConfig - load role config, nil if never set
+func getRoleConfig(stub shim.ChaincodeStubInterface) (*RoleConfig, error) {
+	key, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_ROLES})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	config := RoleConfig{}
+	err = json.Unmarshal(valBytes, &config)
+	if err != nil || config.DocType != DOC_CONFIG {
+		return nil, fmt.Errorf(`invalid role config. (value: "%s")`, string(valBytes))
+	}
+	return &config, nil
+}
+
+// putRoleConfig - stamp role config with current transaction and write it to ledger
+func putRoleConfig(stub shim.ChaincodeStubInterface, config *RoleConfig) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	config.DocType = DOC_CONFIG
+	config.Version = CONFIG_DOC_VERSION
+	config.UpdatedTx = stub.GetTxID()
+	config.UpdatedAt = timestamp
+	key, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_ROLES})
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// initRoles - set role config from deployment argument, or trust the MSP of the deployer for both
+// roles. An existing config is kept, so that upgrades do not reset it.
+func initRoles(stub shim.ChaincodeStubInterface, data string) (*RoleConfig, error) {
+	config, err := getRoleConfig(stub)
+	if err != nil || (config != nil && data == "") {
+		return config, err
+	}
+	config = &RoleConfig{}
+	if data != "" {
+		err = json.Unmarshal([]byte(data), config)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid role config, expecting a JSON document. (value: "%s")`, data)
+		}
+	} else {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return nil, err
+		}
+		config.AdminMSPs = []string{mspID}
+		config.MinterMSPs = []string{mspID}
+	}
+	err = config.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	return config, putRoleConfig(stub, config)
+}
+
+// hasAttribute - check whether the creator carries the attribute with value 'true'
+func hasAttribute(stub shim.ChaincodeStubInterface, attrName string) bool {
+	val, found, err := cid.GetAttributeValue(stub, attrName)
+	if err != nil || !found {
+		return false
+	}
+	return val == "true"
+}
+
+// hasRole - check whether the creator carries the attribute issued by one of the trusted MSPs
+func hasRole(stub shim.ChaincodeStubInterface, attrName string) bool {
+	if !hasAttribute(stub, attrName) {
+		return false
+	}
+	config, err := getRoleConfig(stub)
+	if err != nil || config == nil {
+		return false
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false
+	}
+	if attrName == ATTR_ADMIN {
+		return trusts(config.AdminMSPs, mspID)
+	}
+	return trusts(config.MinterMSPs, mspID)
+}
+
+// isAdmin - check whether the creator is an admin of a trusted MSP
+func isAdmin(stub shim.ChaincodeStubInterface) bool {
+	return hasRole(stub, ATTR_ADMIN)
+}
+
+// isMinter - check whether the creator is allowed to issue money (admin or minter of a trusted MSP)
+func isMinter(stub shim.ChaincodeStubInterface) bool {
+	return isAdmin(stub) || hasRole(stub, ATTR_MINTER)
+}
+
+// authorizeOwner - check whether the creator is the owner recorded when account was created
+func authorizeOwner(stub shim.ChaincodeStubInterface, account *Account) error {
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return fmt.Errorf("failed to resolve creator identity. cause: (%s)", err)
+	}
+	if account.Owner.ID == "" || !account.Owner.Equals(creator) {
+		return fmt.Errorf(`creator is not the owner of account. (account: "%s", creator: "%s" of "%s")`, account.Name, creator.ID, creator.MSPID)
+	}
+	return nil
+}
+
+// authorizeIssuer - check whether the creator belongs to the issuer MSP of asset
+func authorizeIssuer(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("failed to resolve creator MSP. cause: (%s)", err)
+	}
+	if mspID != asset.IssuerMSP {
+		return fmt.Errorf(`creator is not a member of issuer MSP. (asset: "%s", issuer: "%s", creator: "%s")`, asset.Code, asset.IssuerMSP, mspID)
+	}
+	return nil
+}
+
+// setRoles: set the MSPs whose admin and minter attributes are honored (admin only), from a JSON
+// document such as '{"admin_msps":["Org1MSP"],"minter_msps":["Org1MSP","Org2MSP"]}'
+func (t *BalanceManager) setRoles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set roles.")
+	}
+
+	config := RoleConfig{}
+	err := json.Unmarshal([]byte(args[0]), &config)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid role config, expecting a JSON document. cause: (%s)", err))
+	}
+	err = config.Normalize()
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid role config. cause: (%s)", err))
+	}
+	err = putRoleConfig(stub, &config)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ROLES_SET, RolesEvent{AdminMSPs: config.AdminMSPs, MinterMSPs: config.MinterMSPs})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryRoles: query the MSPs whose admin and minter attributes are honored
+func (t *BalanceManager) queryRoles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 0")
+	}
+
+	config, err := getRoleConfig(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if config == nil {
+		config = &RoleConfig{AdminMSPs: []string{}, MinterMSPs: []string{}}
+	}
+
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
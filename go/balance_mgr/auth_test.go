@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RoleConfigNormalize(t *testing.T) {
+	config := RoleConfig{}
+	assert.NotNil(t, config.Normalize(), "an admin MSP is required.")
+
+	config = RoleConfig{AdminMSPs: []string{"Org1MSP", ""}}
+	assert.NotNil(t, config.Normalize())
+
+	config = RoleConfig{AdminMSPs: []string{"Org1MSP"}}
+	assert.Nil(t, config.Normalize())
+	assert.Equal(t, []string{}, config.MinterMSPs)
+
+	assert.True(t, trusts(config.AdminMSPs, "Org1MSP"))
+	assert.False(t, trusts(config.AdminMSPs, "Org2MSP"), "admin attribute of another org is not honored.")
+	assert.False(t, trusts(config.MinterMSPs, "Org1MSP"))
+}
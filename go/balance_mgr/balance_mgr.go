@@ -1,21 +1,24 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
-
-	// "github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
-	//"github.com/chaincodes/common/crypto"
 )
 
 const (
+	// KEY_PUBLIC, KEY_PRIVATE - legacy RSA key-pair kept in world state, removed by upgrade since
+	// encryption keys are supplied through the transient map
 	KEY_PUBLIC  = "KEY_PUBLIC"
 	KEY_PRIVATE = "KEY_PRIVATE"
 	KEY_PREFIX  = "CRYPT_"
+
+	// KEY_PREFIX_CREDIT - credit limits kept beside legacy integer-string balances, folded into account documents by upgrade
+	KEY_PREFIX_CREDIT = "CREDIT_"
 )
 
 // BalanceManager Smart Contract(Chaincode) implementation
@@ -42,65 +45,77 @@ func (t *BalanceManager) Init(stub shim.ChaincodeStubInterface) pb.Response {
 		(expecting 'init' or 'upgrade', actual: '%s')`, funcName))
 }
 
-// func checkCryptoKeyPairExisting(stub shim.ChaincodeStubInterface) bool {
-// 	pubKey, _ := stub.GetState(KEY_PUBLIC)
-// 	privKey, _ := stub.GetState(KEY_PRIVATE)
-// 	return (pubKey != nil && len(pubKey) > 0) && (privKey != nil && len(privKey) > 0)
-// }
-
 func (t *BalanceManager) doInit(stub shim.ChaincodeStubInterface) pb.Response {
 	_, params := stub.GetFunctionAndParameters()
 	paramCount := len(params)
-	if paramCount != 0 && paramCount != 2 {
+	if paramCount > 2 {
 		return shim.Error(fmt.Sprintf(`Incorrect number of arguments. 
-			(expecting: 0 or 2, actual: %d)`, paramCount))
-	}
-
-	// check cryption key-pair existing
-	// if checkCryptoKeyPairExisting(stub) == false {
-	// 	if paramCount == 2 {
-	// 		fmt.Println("Initializing RSA key-pair with deployment arguments ...")
-	// 		stub.PutState(KEY_PUBLIC, []byte(params[0]))
-	// 		stub.PutState(KEY_PRIVATE, []byte(params[1]))
-	// 		fmt.Println("Initialize RSA key-pair for cryption successfully.")
-	// 		return shim.Success(nil)
-	// 	}
-
-	// 	fmt.Println("Generating RSA key-pair ...")
-	// 	publicKey := *bytes.NewBufferString("")
-	// 	privateKey := *bytes.NewBufferString("")
-	// 	err := crypto.CreateKeyPair(&publicKey, &privateKey, 256)
-	// 	if err == nil {
-	// 		stub.PutState(KEY_PUBLIC, publicKey.Bytes())
-	// 		stub.PutState(KEY_PRIVATE, privateKey.Bytes())
-	// 		fmt.Println("Generate RSA key-pair for cryption successfully.")
-	// 		return shim.Success(nil)
-	// 	}
-
-	// 	fmt.Printf("Initialize RSA key-pair for cryption failed. cause: (%s)", err)
-	// 	fmt.Println()
-	// 	return shim.Error(err.Error())
-	// }
-
-	fmt.Println("RSA key-pair already existing, initialization not required.")
+			(expecting: 0 to 2, actual: %d)`, paramCount))
+	}
+
+	// optional JSON role config, the deploying MSP is trusted otherwise
+	fmt.Println("Setting roles ...")
+	roles, err := initRoles(stub, optionalArg(params, 1))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Set roles failed. cause: (%s)", err))
+	}
+	fmt.Printf("Set roles successfully. (admin MSPs: %v, minter MSPs: %v)", roles.AdminMSPs, roles.MinterMSPs)
+	fmt.Println()
+
+	// optional JSON list of assets to register, with their max supply
+	if paramCount >= 1 && params[0] != "" {
+		fmt.Println("Registering assets with deployment arguments ...")
+		registered, err := initAssets(stub, params[0])
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Register assets failed. cause: (%s)", err))
+		}
+		fmt.Printf("Register assets successfully. (count: %d)", registered)
+		fmt.Println()
+	}
+
 	return shim.Success(nil)
 }
 
 func (t *BalanceManager) doUpgrade(stub shim.ChaincodeStubInterface) pb.Response {
 	_, params := stub.GetFunctionAndParameters()
 	paramCount := len(params)
-	if paramCount != 0 && paramCount != 2 {
+	if paramCount != 0 {
 		return shim.Error(fmt.Sprintf(`Incorrect number of arguments. 
-			(expecting: 0 or 2, actual: %d)`, paramCount))
+			(expecting: 0, actual: %d)`, paramCount))
 	}
 
-	if paramCount == 2 {
-		fmt.Println("Initializing RSA key-pair with deployment arguments ...")
-		stub.PutState(KEY_PUBLIC, []byte(params[0]))
-		stub.PutState(KEY_PRIVATE, []byte(params[1]))
-		fmt.Println("Initialize RSA key-pair for cryption successfully.")
+	fmt.Println("Removing RSA key-pair from world state ...")
+	for _, key := range []string{KEY_PUBLIC, KEY_PRIVATE} {
+		err := stub.DelState(key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Remove RSA key-pair failed. cause: (%s)", err))
+		}
 	}
 
+	fmt.Println("Setting roles ...")
+	roles, err := initRoles(stub, "")
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Set roles failed. cause: (%s)", err))
+	}
+	fmt.Printf("Set roles successfully. (admin MSPs: %v, minter MSPs: %v)", roles.AdminMSPs, roles.MinterMSPs)
+	fmt.Println()
+
+	fmt.Println("Migrating accounts ...")
+	migrated, err := migrateAccounts(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Migrate accounts failed. cause: (%s)", err))
+	}
+	fmt.Printf("Migrate accounts successfully. (count: %d)", migrated)
+	fmt.Println()
+
+	fmt.Println("Seeding asset supplies ...")
+	seeded, err := seedSupplies(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Seed asset supplies failed. cause: (%s)", err))
+	}
+	fmt.Printf("Seed asset supplies successfully. (count: %d)", seeded)
+	fmt.Println()
+
 	return shim.Success(nil)
 }
 
@@ -108,15 +123,95 @@ func (t *BalanceManager) doUpgrade(stub shim.ChaincodeStubInterface) pb.Response
 func (t *BalanceManager) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	funcName, args := stub.GetFunctionAndParameters()
 	fmt.Printf("<BalanceMgr Invoke>: %s", funcName)
+	if IDEMPOTENT_FUNCTIONS[funcName] {
+		return t.invokeOnce(stub, funcName, args)
+	}
+	return t.dispatch(stub, funcName, args)
+}
+
+// dispatch - call invoke function by name
+func (t *BalanceManager) dispatch(stub shim.ChaincodeStubInterface, funcName string, args []string) pb.Response {
 	if funcName == "create" {
 		// Create account with balance of '0'
 		return t.create(stub, args)
 	} else if funcName == "charge" {
 		// Charge account with amount
 		return t.charge(stub, args)
+	} else if funcName == "mint" {
+		// Mint amount into account (issuer only)
+		return t.mint(stub, args)
+	} else if funcName == "burn" {
+		// Burn amount of account (issuer only)
+		return t.burn(stub, args)
+	} else if funcName == "supply" {
+		// Query supply of asset
+		return t.querySupply(stub, args)
+	} else if funcName == "verifySupply" {
+		// Verify supply against the sum of account balances (admin only)
+		return t.verifySupply(stub, args)
 	} else if funcName == "transfer" {
 		// Transfer A to B with some money
 		return t.transfer(stub, args)
+	} else if funcName == "transferFrom" {
+		// Transfer balance on behalf of the owner within allowance
+		return t.transferFrom(stub, args)
+	} else if funcName == "approve" {
+		// Approve spender to transfer from accounts of the creator
+		return t.approve(stub, args)
+	} else if funcName == "revoke" {
+		// Revoke allowance of spender
+		return t.revoke(stub, args)
+	} else if funcName == "allowance" {
+		// Query allowance of spender
+		return t.queryAllowance(stub, args)
+	} else if funcName == "batchTransfer" {
+		// Transfer a list of legs atomically
+		return t.batchTransfer(stub, args)
+	} else if funcName == "exchange" {
+		// Swap amounts of two assets between two accounts
+		return t.exchange(stub, args)
+	} else if funcName == "statement" {
+		// Query journal entries of account over a time range
+		return t.statement(stub, args)
+	} else if funcName == "registerAsset" {
+		// Register asset by code (admin only)
+		return t.registerAsset(stub, args)
+	} else if funcName == "asset" {
+		// Query registered asset
+		return t.queryAsset(stub, args)
+	} else if funcName == "hold" {
+		// Hold amount of account in escrow for beneficiary
+		return t.hold(stub, args)
+	} else if funcName == "release" {
+		// Release held amount to beneficiary
+		return t.release(stub, args)
+	} else if funcName == "cancelHold" {
+		// Cancel hold, returning held amount to available balance
+		return t.cancelHold(stub, args)
+	} else if funcName == "queryHold" {
+		// Query hold by id
+		return t.queryHold(stub, args)
+	} else if funcName == "setFeeSchedule" {
+		// Set transfer fee schedule of asset (admin only)
+		return t.setFeeSchedule(stub, args)
+	} else if funcName == "feeSchedule" {
+		// Query transfer fee schedule of asset
+		return t.queryFeeSchedule(stub, args)
+	} else if funcName == "freeze" {
+		// Freeze account (admin only)
+		return t.freeze(stub, args)
+	} else if funcName == "unfreeze" {
+		// Unfreeze account (admin only)
+		return t.unfreeze(stub, args)
+	} else if funcName == "close" {
+		// Close account, optionally sweeping balances into another account (admin only)
+		return t.close(stub, args)
+	} else if funcName == "setCreditLimit" {
+		// Set credit limit of account (admin only)
+		return t.setCreditLimit(stub, args)
+	} else if funcName == "assignOwner" {
+		// Assign owner of account, e.g. migrated legacy account (admin only)
+		return t.assignOwner(stub, args)
 	} else if funcName == "query" {
 		// Query account balance
 		return t.query(stub, args)
@@ -126,399 +221,993 @@ func (t *BalanceManager) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	} else if funcName == "put" {
 		// Put normal val
 		return t.put(stub, args)
-	} else if funcName == "json" {
-		// Put normal val
-		return t.json(stub, args)
-	} else if funcName == "event" {
-		// Put decrypted val
-		return t.sendEvent(stub, args)
-	}
-	//  else if funcName == "getP" {
-	// 	return t.getPrivateData(stub, args)
-	// } else if funcName == "putP" {
-	// 	return t.putPrivateData(stub, args)
-	// } else if funcName == "getX" {
-	// 	// Get encrypted val
-	// 	return t.getDecryption(stub, args)
-	// } else if funcName == "putX" {
-	// 	// Put decrypted val
-	// 	return t.putEncryption(stub, args)
-	// }
-
-	return shim.Error(fmt.Sprintf(`Invalid invoke function name. Expecting 'create','transfer',
-	'query', 'get', 'getX', 'put', 'putX', 'json' and 'event'. Actual: '%s'`, funcName))
+	} else if funcName == "queryAccounts" {
+		// Query accounts by structured filter with pagination
+		return t.queryAccounts(stub, args)
+	} else if funcName == "setMetadata" {
+		// Encrypt account metadata given in transient map
+		return t.setMetadata(stub, args)
+	} else if funcName == "getMetadata" {
+		// Decrypt account metadata with keys given in transient map
+		return t.getMetadata(stub, args)
+	} else if funcName == "rotateMetadata" {
+		// Re-encrypt account metadata with current key version
+		return t.rotateMetadata(stub, args)
+	} else if funcName == "shield" {
+		// Move amount into confidential balance
+		return t.shield(stub, args)
+	} else if funcName == "unshield" {
+		// Move amount out of confidential balance
+		return t.unshield(stub, args)
+	} else if funcName == "transferPrivate" {
+		// Transfer between confidential balances
+		return t.transferPrivate(stub, args)
+	} else if funcName == "claimPrivate" {
+		// Fold confidential credits into balance
+		return t.claimPrivate(stub, args)
+	} else if funcName == "privateBalance" {
+		// Query confidential balance
+		return t.privateBalance(stub, args)
+	} else if funcName == "verifyPrivateBalance" {
+		// Verify disclosed confidential balance against hash on ledger
+		return t.verifyPrivateBalance(stub, args)
+	} else if funcName == "addAccountOrgs" {
+		// Add orgs to endorsement policy of account
+		return t.addAccountOrgs(stub, args)
+	} else if funcName == "removeAccountOrgs" {
+		// Remove orgs from endorsement policy of account
+		return t.removeAccountOrgs(stub, args)
+	} else if funcName == "accountOrgs" {
+		// Query orgs of endorsement policy of account
+		return t.queryAccountOrgs(stub, args)
+	} else if funcName == "request" {
+		// Query outcome of client request id
+		return t.queryRequest(stub, args)
+	} else if funcName == "setRequestWindow" {
+		// Set time request ids are remembered (admin only)
+		return t.setRequestWindow(stub, args)
+	} else if funcName == "purgeRequests" {
+		// Remove expired request ids (admin only)
+		return t.purgeRequests(stub, args)
+	} else if funcName == "schedule" {
+		// Persist standing order of recurring transfers
+		return t.schedule(stub, args)
+	} else if funcName == "cancelSchedule" {
+		// Cancel standing order
+		return t.cancelSchedule(stub, args)
+	} else if funcName == "executeDue" {
+		// Execute due standing orders (any keeper)
+		return t.executeDue(stub, args)
+	} else if funcName == "order" {
+		// Query standing order
+		return t.queryOrder(stub, args)
+	} else if funcName == "export" {
+		// Export page of assets or accounts as JSON lines (admin only)
+		return t.export(stub, args)
+	} else if funcName == "import" {
+		// Import page of export into a fresh channel (admin only)
+		return t.importPage(stub, args)
+	} else if funcName == "setAlias" {
+		// Add unique alias to account (owner or admin)
+		return t.setAlias(stub, args)
+	} else if funcName == "removeAlias" {
+		// Free alias of account (owner or admin)
+		return t.removeAlias(stub, args)
+	} else if funcName == "transferAlias" {
+		// Move alias to another account (owner or admin)
+		return t.transferAlias(stub, args)
+	} else if funcName == "resolveAlias" {
+		// Query account of alias
+		return t.resolveAlias(stub, args)
+	} else if funcName == "ownerAccounts" {
+		// List accounts of owner
+		return t.ownerAccounts(stub, args)
+	} else if funcName == "setInterestRate" {
+		// Set interest rate and pool of asset (admin only)
+		return t.setInterestRate(stub, args)
+	} else if funcName == "setAccountInterestRate" {
+		// Override interest rate of asset for account (admin only)
+		return t.setAccountInterestRate(stub, args)
+	} else if funcName == "interestRate" {
+		// Query interest rate of asset applying to account
+		return t.queryInterestRate(stub, args)
+	} else if funcName == "accrue" {
+		// Post interest earned by balance of account
+		return t.accrue(stub, args)
+	} else if funcName == "accrueAll" {
+		// Post interest of every balance of asset (admin only)
+		return t.accrueAll(stub, args)
+	} else if funcName == "setSpendingLimit" {
+		// Set spending limits of account or account class (admin only)
+		return t.setSpendingLimit(stub, args)
+	} else if funcName == "setAccountClass" {
+		// Assign account class (admin only)
+		return t.setAccountClass(stub, args)
+	} else if funcName == "spendingLimit" {
+		// Query spending limit and usage of account
+		return t.querySpendingLimit(stub, args)
+	} else if funcName == "recordLimitBreach" {
+		// Record refused outgoing amount for monitoring (owner, signer or admin)
+		return t.recordLimitBreach(stub, args)
+	} else if funcName == "setSignerPolicy" {
+		// Set M-of-N signer approval of large transfers from account (admin only)
+		return t.setSignerPolicy(stub, args)
+	} else if funcName == "signerPolicy" {
+		// Query signer policy of account
+		return t.querySignerPolicy(stub, args)
+	} else if funcName == "proposeTransfer" {
+		// Propose a transfer needing approval of the signers of the paying account
+		return t.proposeTransfer(stub, args)
+	} else if funcName == "approveTransfer" {
+		// Approve a transfer proposal, executing it once enough signers approved
+		return t.approveTransfer(stub, args)
+	} else if funcName == "rejectTransfer" {
+		// Reject a transfer proposal
+		return t.rejectTransfer(stub, args)
+	} else if funcName == "proposal" {
+		// Query transfer proposal
+		return t.queryProposal(stub, args)
+	} else if funcName == "setRoles" {
+		// Set MSPs trusted to issue admin and minter attributes (admin only)
+		return t.setRoles(stub, args)
+	} else if funcName == "roles" {
+		// Query MSPs trusted to issue admin and minter attributes
+		return t.queryRoles(stub, args)
+	} else if funcName == "reconcile" {
+		// Prove balance of account against key history and journal
+		return t.reconcileAccount(stub, args)
+	}
+
+	return shim.Error(fmt.Sprintf(`Invalid invoke function name. Expecting 'create','charge','mint','burn','supply','verifySupply','transfer', 'transferFrom', 'approve', 'revoke', 'allowance',
+	'batchTransfer', 'exchange', 'statement', 'registerAsset', 'asset', 'hold', 'release', 'cancelHold', 'queryHold', 'setFeeSchedule', 'feeSchedule', 'freeze', 'unfreeze', 'close', 'setCreditLimit', 'assignOwner', 'query', 'get', 'put', 'queryAccounts', 'setMetadata', 'getMetadata', 'rotateMetadata',
+	'shield', 'unshield', 'transferPrivate', 'claimPrivate', 'privateBalance', 'verifyPrivateBalance',
+	'addAccountOrgs', 'removeAccountOrgs', 'accountOrgs', 'request', 'setRequestWindow', 'purgeRequests',
+	'schedule', 'cancelSchedule', 'executeDue', 'order', 'export', 'import',
+	'setAlias', 'removeAlias', 'transferAlias', 'resolveAlias', 'ownerAccounts',
+	'setInterestRate', 'setAccountInterestRate', 'interestRate', 'accrue', 'accrueAll',
+	'setSpendingLimit', 'setAccountClass', 'spendingLimit', 'recordLimitBreach',
+	'setSignerPolicy', 'signerPolicy', 'proposeTransfer', 'approveTransfer', 'rejectTransfer', 'proposal', 'reconcile', 'setRoles' and 'roles'. Actual: '%s'`, funcName))
 }
 
-// create: create account initialized with 0
+// create: create account, or open a balance of another asset for an existing account, initialized with 0
 func (t *BalanceManager) create(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("create account with initial balance of '0'")
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
 	}
 
 	accountName := args[0]
+	assetCode := args[1]
+
+	asset, resp := loadAsset(stub, assetCode)
+	if asset == nil {
+		return resp
+	}
 
-	valBytes, err := stub.GetState(accountName)
+	account, err := getAccount(stub, accountName)
 	if err != nil {
-		return shim.Error("Account create failed with unknown reason.")
+		return errorResponse(ERR_LEDGER, "Account create failed with unknown reason.")
 	}
 
-	if valBytes != nil && len(valBytes) > 0 {
-		return shim.Error(fmt.Sprintf(`Account already existed. (Account: "%s")`, accountName))
+	if account == nil {
+		owner, err := creatorIdentity(stub)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+		}
+		account = NewAccount(accountName, owner)
+		// updates of the account need endorsement from the owner org from now on
+		err = stampAccountPolicy(stub, accountName, owner.MSPID)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = indexOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	} else {
+		err = authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+		if resp, inactive := notActive(account); inactive {
+			return resp
+		}
+		if account.GetBalance(assetCode) != nil {
+			return errorResponse(ERR_ACCOUNT_EXISTS, fmt.Sprintf(`Account already existed. (Account: "%s", Asset: "%s")`, accountName, assetCode))
+		}
 	}
 
-	stub.PutState(accountName, []byte(strconv.Itoa(0)))
+	balance := account.OpenBalance(assetCode, asset.Decimals)
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
 
-	byts, err := stub.GetCreator()
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.Append(ENTRY_OPEN, accountName, assetCode, "", ZeroAmount(asset.Decimals), balance.Amount, "")
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
 
+	eventType := EVENT_BALANCE_OPENED
+	if len(account.Balances) == 1 {
+		eventType = EVENT_ACCOUNT_CREATED
+	}
+	err = emitEvent(stub, eventType, AccountEvent{Account: accountName, Asset: assetCode, Owner: account.Owner})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
 	}
-	fmt.Printf(`Printing current user is "%s".`, string(byts))
+
+	fmt.Printf(`Account "%s" of "%s" created for "%s" of "%s".`, accountName, assetCode, account.Owner.ID, account.Owner.MSPID)
 	fmt.Println()
 	return shim.Success(nil)
 }
 
+// charge: charge account with amount, issued into supply of asset like mint
 func (t *BalanceManager) charge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("charge account with amount")
+	return t.issue(stub, args, ENTRY_CHARGE, EVENT_CHARGED)
+}
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+// transfer: Transfer balance from account to another. The fee of the asset's fee schedule is paid
+// by the sender on top of amount to the collector account.
+func (t *BalanceManager) transfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("transfer account")
+	return t.transferFunds(stub, args, false)
+}
+
+// transferFunds - transfer authorized by owning the sender, or delegated by an allowance the owner
+// of the sender granted to the creator
+func (t *BalanceManager) transferFunds(stub shim.ChaincodeStubInterface, args []string, delegated bool) pb.Response {
+	if len(args) != 4 && len(args) != 5 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 4 or 5")
 	}
 
 	accountFrom := args[0]
+	accountTo := args[1]
+	assetCode := args[2]
+	if accountFrom == accountTo {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Transfer to the same account is not allowed. (Account: "%s")`, accountFrom))
+	}
 
-	bytesFrom, err := stub.GetState(accountFrom)
-	if err != nil {
-		return shim.Error("Account charge failed with unknown reason.")
+	asset, resp := loadAsset(stub, assetCode)
+	if asset == nil {
+		return resp
 	}
-	if bytesFrom == nil {
-		return shim.Error("Account not found")
+
+	// Get the state from the ledger, the collector may be the receiver
+	cache := newAccountCache(stub)
+	from, _, resp := cachedBalance(cache, accountFrom, asset)
+	if from == nil {
+		return resp
+	}
+	if !delegated {
+		err := authorizeOwner(stub, from)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
 	}
-	valFrom, _ := strconv.Atoi(string(bytesFrom))
 
 	// Perform the execution
-	amountCharge, err := strconv.Atoi(args[1])
+	amountTransfer, err := parseAmount(args[3], asset)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	resp = requireApproval(stub, accountFrom, asset, amountTransfer)
+	if resp.Status != shim.OK {
+		return resp
 	}
-	valFrom = valFrom + amountCharge
 
-	stub.PutState(accountFrom, []byte(strconv.Itoa(valFrom)))
+	var spender *Identity
+	if delegated {
+		allowance, resp := spendAllowance(stub, from, asset, amountTransfer)
+		if allowance == nil {
+			return resp
+		}
+		spender = &allowance.Spender
+	}
 
-	stub.SetEvent("hello", []byte(fmt.Sprintf(`{"status":"ok", "account":"%s", "amount":"%d"}`, accountFrom, amountCharge)))
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	event, resp := moveFunds(stub, cache, journal, accountFrom, accountTo, asset, amountTransfer, "", optionalArg(args, 4))
+	if event == nil {
+		return resp
+	}
+	event.Spender = spender
+
+	// Write the state back to the ledger
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_TRANSFERRED, event)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
 
 	return shim.Success(nil)
 }
 
-// transfer: Transfer balance from account to another
-func (t *BalanceManager) transfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("transfer account")
+// moveFunds - transfer amount between accounts loaded through cache, the fee of asset is paid on top by
+// the sender. Entries are journaled with the optional reference, the error response is returned before
+// any balance is changed unless the ledger fails, except that interest accrued by the accounts is posted.
+func moveFunds(stub shim.ChaincodeStubInterface, cache *accountCache, journal *Journal, accountFrom string, accountTo string, asset *Asset, amountTransfer Amount, reference string, memo string) (*TransferEvent, pb.Response) {
+	assetCode := asset.Code
+	from, balanceFrom, resp := cachedBalance(cache, accountFrom, asset)
+	if from == nil {
+		return nil, resp
+	}
+	to, balanceTo, resp := cachedBalance(cache, accountTo, asset)
+	if to == nil {
+		return nil, resp
+	}
+	if resp, inactive := notActive(from); inactive {
+		return nil, resp
+	}
+	if resp, inactive := notActive(to); inactive {
+		return nil, resp
+	}
+	fee := ZeroAmount(asset.Decimals)
+	schedule, err := getFeeSchedule(stub, assetCode)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	var collector *Account
+	var balanceCollector *Balance
+	if schedule != nil && schedule.Collector != accountFrom {
+		fee = schedule.Compute(amountTransfer)
+		collector, balanceCollector, resp = cachedBalance(cache, schedule.Collector, asset)
+		if collector == nil {
+			return nil, resp
+		}
+		if resp, inactive := notActive(collector); inactive {
+			return nil, resp
+		}
+	}
+	resp = accrueTouched(stub, cache, journal, asset, from, to, collector)
+	if resp.Status != shim.OK {
+		return nil, resp
+	}
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if !balanceFrom.CanDebit(amountTransfer.Add(fee)) {
+		return nil, insufficientFunds(accountFrom, assetCode, balanceFrom, amountTransfer.Add(fee))
+	}
+	// counted last, so that a refused transfer never consumes the limit
+	resp = cache.spending.spend(from, asset, amountTransfer)
+	if resp.Status != shim.OK {
+		return nil, resp
 	}
 
-	accountFrom := args[0]
-	accountTo := args[1]
+	balanceFrom.Amount = balanceFrom.Amount.Sub(amountTransfer)
+	err = journal.AppendRef(ENTRY_TRANSFER_OUT, accountFrom, assetCode, accountTo, amountTransfer.Neg(), balanceFrom.Amount, reference, memo)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	balanceTo.Amount = balanceTo.Amount.Add(amountTransfer)
+	err = journal.AppendRef(ENTRY_TRANSFER_IN, accountTo, assetCode, accountFrom, amountTransfer, balanceTo.Amount, reference, memo)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
 
-	// Get the state from the ledger
-	bytesFrom, err := stub.GetState(accountFrom)
+	if fee.Sign() > 0 {
+		balanceFrom.Amount = balanceFrom.Amount.Sub(fee)
+		err = journal.AppendRef(ENTRY_FEE, accountFrom, assetCode, collector.Name, fee.Neg(), balanceFrom.Amount, reference, memo)
+		if err != nil {
+			return nil, errorResponse(ERR_LEDGER, err.Error())
+		}
+		balanceCollector.Amount = balanceCollector.Amount.Add(fee)
+		err = journal.AppendRef(ENTRY_FEE_IN, collector.Name, assetCode, accountFrom, fee, balanceCollector.Amount, reference, memo)
+		if err != nil {
+			return nil, errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+	fmt.Printf("valFrom = %s, valTo = %s, fee = %s\n", balanceFrom.Amount, balanceTo.Amount, fee)
+	fmt.Println()
+
+	event := TransferEvent{From: accountFrom, To: accountTo, Asset: assetCode, Amount: amountTransfer, Fee: fee, Memo: memo}
+	if fee.Sign() > 0 {
+		event.Collector = collector.Name
+	}
+	return &event, shim.Success(nil)
+}
+
+// exchange: atomic swap, account A pays amount A of asset A to account B, account B pays amount B of asset B to account A.
+// Only one identity creates the proposal, so the leg of account B is authorized either by owning both accounts
+// or by an admin acting as exchange operator.
+func (t *BalanceManager) exchange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("exchange assets between accounts")
+
+	if len(args) != 6 && len(args) != 7 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 6 or 7")
+	}
+
+	accountA, accountB := args[0], args[3]
+	if accountA == accountB {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Exchange within the same account is not allowed. (Account: "%s")`, accountA))
+	}
+	if args[1] == args[4] {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Exchange of the same asset is not allowed, use transfer instead. (Asset: "%s")`, args[1]))
+	}
+
+	assetA, resp := loadAsset(stub, args[1])
+	if assetA == nil {
+		return resp
+	}
+	assetB, resp := loadAsset(stub, args[4])
+	if assetB == nil {
+		return resp
+	}
+
+	cache := newAccountCache(stub)
+	a, payA, resp := cachedBalance(cache, accountA, assetA)
+	if a == nil {
+		return resp
+	}
+	b, payB, resp := cachedBalance(cache, accountB, assetB)
+	if b == nil {
+		return resp
+	}
+	receiveA, resp := accountBalance(a, assetB)
+	if receiveA == nil {
+		return resp
+	}
+	receiveB, resp := accountBalance(b, assetA)
+	if receiveB == nil {
+		return resp
+	}
+	if resp, inactive := notActive(a); inactive {
+		return resp
+	}
+	if resp, inactive := notActive(b); inactive {
+		return resp
+	}
+
+	amountA, err := parseAmount(args[2], assetA)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
 	}
-	if bytesFrom == nil {
-		return shim.Error("Entity not found")
+	amountB, err := parseAmount(args[5], assetB)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
 	}
-	valFrom, _ := strconv.Atoi(string(bytesFrom))
 
-	bytesTo, err := stub.GetState(accountTo)
+	err = authorizeOwner(stub, a)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(ERR_ACCESS_DENIED, err.Error())
 	}
-	if bytesTo == nil {
-		return shim.Error("Entity not found")
+	if !isAdmin(stub) {
+		err = authorizeOwner(stub, b)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
 	}
-	valTo, _ := strconv.Atoi(string(bytesTo))
 
-	// Perform the execution
-	amountTransfer, err := strconv.Atoi(args[2])
+	journal, err := newJournal(stub)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, assetA, a, b)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	resp = accrueTouched(stub, cache, journal, assetB, a, b)
+	if resp.Status != shim.OK {
+		return resp
 	}
-	valFrom = valFrom - amountTransfer
-	valTo = valTo + amountTransfer
-	fmt.Printf("valFrom = %d, valTo = %d\n", valFrom, valTo)
-	fmt.Println()
 
-	// Write the state back to the ledger
-	err = stub.PutState(accountFrom, []byte(strconv.Itoa(valFrom)))
+	if !payA.CanDebit(amountA) {
+		return insufficientFunds(accountA, assetA.Code, payA, amountA)
+	}
+	if !payB.CanDebit(amountB) {
+		return insufficientFunds(accountB, assetB.Code, payB, amountB)
+	}
+	resp = requireApproval(stub, accountA, assetA, amountA)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	resp = requireApproval(stub, accountB, assetB, amountB)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	resp = cache.spending.spend(a, assetA, amountA)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	resp = cache.spending.spend(b, assetB, amountB)
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	payA.Amount = payA.Amount.Sub(amountA)
+	receiveB.Amount = receiveB.Amount.Add(amountA)
+	payB.Amount = payB.Amount.Sub(amountB)
+	receiveA.Amount = receiveA.Amount.Add(amountB)
+
+	err = cache.flush()
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	memo := optionalArg(args, 6)
+	entries := []struct {
+		entryType    EntryType
+		account      string
+		asset        string
+		counterparty string
+		amount       Amount
+		balance      Amount
+	}{
+		{ENTRY_EXCHANGE_OUT, accountA, assetA.Code, accountB, amountA.Neg(), payA.Amount},
+		{ENTRY_EXCHANGE_IN, accountA, assetB.Code, accountB, amountB, receiveA.Amount},
+		{ENTRY_EXCHANGE_OUT, accountB, assetB.Code, accountA, amountB.Neg(), payB.Amount},
+		{ENTRY_EXCHANGE_IN, accountB, assetA.Code, accountA, amountA, receiveB.Amount},
+	}
+	for _, entry := range entries {
+		err = journal.Append(entry.entryType, entry.account, entry.asset, entry.counterparty, entry.amount, entry.balance, memo)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
 	}
 
-	err = stub.PutState(accountTo, []byte(strconv.Itoa(valTo)))
+	err = emitEvent(stub, EVENT_EXCHANGED, ExchangeEvent{AccountA: accountA, AssetA: assetA.Code, AmountA: amountA, AccountB: accountB, AssetB: assetB.Code, AmountB: amountB, Memo: memo})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
 	}
 
 	return shim.Success(nil)
 }
 
-// query: query account balance
-func (t *BalanceManager) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A string // Entities
-	var err error
+// registerAsset: register asset by code with its decimals, issuer MSP and optional max supply (admin only)
+func (t *BalanceManager) registerAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3 or 4")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to register asset.")
 	}
 
-	A = args[0]
+	assetCode := args[0]
+	decimals, err := strconv.Atoi(args[1])
+	if err != nil || decimals < 0 || decimals > MAX_DECIMALS {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid decimals, expecting an integer between 0 and %d. (actual: "%s")`, MAX_DECIMALS, args[1]))
+	}
+	issuerMSP := args[2]
+	if assetCode == "" || issuerMSP == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Asset code and issuer MSP id must not be empty.")
+	}
+	maxSupply, err := parseMaxSupply(optionalArg(args, 3), decimals)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
 
-	// Get the state from the ledger
-	Avalbytes, err := stub.GetState(A)
+	asset, err := getAsset(stub, assetCode)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if asset != nil {
+		return errorResponse(ERR_ASSET_EXISTS, fmt.Sprintf(`Asset already registered. (Asset: "%s")`, assetCode))
 	}
 
-	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return shim.Error(jsonResp)
+	err = putAsset(stub, NewAsset(assetCode, decimals, issuerMSP))
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = putSupply(stub, NewSupply(assetCode, decimals, maxSupply))
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
 	}
 
-	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
-	fmt.Printf("Query Response:%s\n", jsonResp)
-	fmt.Println()
+	err = emitEvent(stub, EVENT_ASSET_REGISTERED, AssetEvent{Asset: assetCode, Decimals: decimals, IssuerMSP: issuerMSP, MaxSupply: maxSupply})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
 
-	byts, err := stub.GetCreator()
+// queryAsset: query registered asset
+func (t *BalanceManager) queryAsset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if asset == nil {
+		return errorResponse(ERR_ASSET_NOT_FOUND, fmt.Sprintf(`Asset not registered. (Asset: "%s")`, args[0]))
+	}
 
+	bytes, err := json.Marshal(asset)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	fmt.Printf(`Printing current user is "%s".`, string(byts))
-	fmt.Println()
+	return shim.Success(bytes)
+}
 
-	// id, err := cid.GetID(stub)
-
-	// if err != nil {
-	// 	fmt.Printf(`GetID failed. Error: "%s".`, err.Error())
-	// } else {
-	// 	fmt.Printf(`GetID="%s".`, id)
-	// }
-	// fmt.Println()
-
-	// mspId, err := cid.GetMSPID(stub)
-	// if err != nil {
-	// 	fmt.Printf(`GetMSPID failed. Error: "%s".`, err.Error())
-	// } else {
-	// 	fmt.Printf(`GetMSPID="%s".`, mspId)
-	// }
-	// fmt.Println()
-
-	// email_val, email_found, err := cid.GetAttributeValue(stub, "email")
-	// if err != nil {
-	// 	fmt.Printf(`GetAttributeValue('email') failed. Error: "%s".`, err.Error())
-	// } else if !email_found {
-	// 	fmt.Printf(`GetAttributeValue('email') not found. Value: "%s".`, email_val)
-	// } else {
-	// 	fmt.Printf(`GetAttributeValue('email') successfully. Value: "%s".`, email_val)
-	// }
-	// fmt.Println()
-
-	// admin_val, admin_found, err := cid.GetAttributeValue(stub, "admin")
-	// if err != nil {
-	// 	fmt.Printf(`GetAttributeValue('admin') failed. Error: "%s".`, err.Error())
-	// } else if !admin_found {
-	// 	fmt.Printf(`GetAttributeValue('admin') not found. Value: "%s".`, admin_val)
-	// } else {
-	// 	fmt.Printf(`GetAttributeValue('admin') successfully. Value: "%s".`, admin_val)
-	// }
-	// fmt.Println()
-
-	// err = cid.AssertAttributeValue(stub, "admin", "true")
-	// if err != nil {
-	// 	fmt.Printf(`AssertAttributeValue('admin', 'true') failed. Error: "%s".`, err.Error())
-	// } else {
-	// 	fmt.Printf(`AssertAttributeValue('admin', 'true') successfully.`)
-	// }
-	// fmt.Println()
-
-	// cert, err := cid.GetX509Certificate(stub)
-	// if err != nil {
-	// 	fmt.Printf(`GetX509Certificate failed. Error: "%s".`, err.Error())
-	// } else {
-	// 	fmt.Printf(`GetX509Certificate="%s".`, cert.Raw)
-	// }
-
-	// fmt.Println()
+// setCreditLimit: set how far below zero a balance of account may be drawn (admin only)
+func (t *BalanceManager) setCreditLimit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
 
-	return shim.Success(Avalbytes)
-}
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set credit limit.")
+	}
 
-func (t *BalanceManager) put(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	accountName := args[0]
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	creditLimit, err := ParseAmount(args[2], asset.Decimals)
+	if err != nil || creditLimit.Sign() < 0 {
+		return errorResponse(ERR_INVALID_AMOUNT, fmt.Sprintf(`Invalid credit limit, expecting a non-negative value with at most %d decimals. (actual: "%s")`, asset.Decimals, args[2]))
 	}
 
-	key := args[0]
-	val := args[1]
+	account, balance, resp := loadBalance(stub, accountName, asset)
+	if account == nil {
+		return resp
+	}
 
-	err := stub.PutState(key, []byte(val))
+	balance.CreditLimit = creditLimit
+	err = putAccount(stub, account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_CREDIT_LIMIT_SET, CreditLimitEvent{Account: accountName, Asset: asset.Code, CreditLimit: creditLimit})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
 	}
 
 	return shim.Success(nil)
 }
 
-func (t *BalanceManager) get(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// assignOwner: assign owner identity of account (admin only)
+func (t *BalanceManager) assignOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
 	}
 
-	key := args[0]
-	val, err := stub.GetState(key)
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to assign account owner.")
+	}
 
+	accountName := args[0]
+	owner := Identity{ID: args[1], MSPID: args[2]}
+	if owner.ID == "" || owner.MSPID == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Owner id and MSP id must not be empty.")
+	}
+
+	account, err := getAccount(stub, accountName)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	// confidential funds live in the collection of the owner org and would be stranded there
+	if account.Owner.MSPID != "" && account.Owner.MSPID != owner.MSPID {
+		shielded, err := hasShieldedFunds(stub, account)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if shielded {
+			return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Confidential balances must be unshielded and credits claimed to move account to another org. (Account: "%s", org: "%s")`,
+				accountName, account.Owner.MSPID))
+		}
 	}
 
-	return shim.Success(val)
+	err = unindexOwner(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	account.Owner = owner
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = indexOwner(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	// the new owner org joins the endorsement policy, orgs of previous owners are removed by removeAccountOrgs
+	err = stampAccountPolicy(stub, accountName, owner.MSPID)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_OWNER_ASSIGNED, OwnerEvent{Account: accountName, Owner: owner})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
 }
 
-func (t *BalanceManager) json(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// statement: journal entries of account within [from, to) with pagination (owner or admin only).
+// Empty from or to leaves the range open. Entries are keyed by timestamp, so pages follow in time order.
+func (t *BalanceManager) statement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 5")
 	}
 
-	selector := args[0]
-	queryIt, err := stub.GetQueryResult(selector)
+	accountName := args[0]
+	from, err := parseTimeBound(args[1])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	to, err := parseTimeBound(args[2])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	pageSize, err := parsePageSize(args[3])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	bookmark := args[4]
 
+	account, err := getAccount(stub, accountName)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if !isAdmin(stub) {
+		err = authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
 	}
 
-	var buff bytes.Buffer
-	buff.WriteString("[")
+	var fromKey, toKey string
+	if !from.IsZero() {
+		fromKey = from.UTC().Format(TIMESTAMP_FORMAT)
+	}
+	if !to.IsZero() {
+		toKey = to.UTC().Format(TIMESTAMP_FORMAT)
+	}
+	startKey, endKey := journalRange(accountName, fromKey, toKey)
+	resultIt, metadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
 
-	for queryIt.HasNext() {
-		queryResult, err := queryIt.Next()
+	entries := make([]*JournalEntry, 0)
+	for resultIt.HasNext() {
+		response, err := resultIt.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(ERR_LEDGER, err.Error())
 		}
-
-		if buff.Len() > 1 {
-			buff.WriteString(",")
+		entry, err := ParseJournalEntry(response.Value)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
 		}
-		buff.WriteString(string(queryResult.GetValue()))
+		entries = append(entries, entry)
+	}
+
+	page := Page{PageTitle: PageTitle{Count: int32(len(entries)), Bookmark: metadata.Bookmark}, PageData: entries}
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	return shim.Success(bytes)
+}
 
-	buff.WriteString("]")
+// optionalArg - argument at index, empty if not given
+func optionalArg(args []string, idx int) string {
+	if len(args) > idx {
+		return args[idx]
+	}
+	return ""
+}
 
-	return shim.Success(buff.Bytes())
+// parseTimeBound - parse RFC3339 time, empty value means unbounded
+func parseTimeBound(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+	tm, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`Invalid time, expecting RFC3339 format. (actual: "%s")`, val)
+	}
+	return tm, nil
 }
 
-// func (t *BalanceManager) putEncryption(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-// 	if len(args) != 2 {
-// 		return shim.Error("Incorrect number of arguments. Expecting 2")
-// 	}
-
-// 	key := args[0]
-// 	val := args[1]
-// 	pubKey, err := stub.GetState(KEY_PUBLIC)
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-// 	helper, errs := crypto.NewRSAHelper(pubKey, nil)
-// 	if errs != nil && len(errs) > 0 {
-// 		return shim.Error(err.Error())
-// 	}
-// 	encoded, err := helper.Encrypt(val)
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-// 	err = stub.PutState(KEY_PREFIX+key, []byte(encoded))
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-
-// 	return shim.Success(nil)
-// }
-
-// func (t *BalanceManager) getDecryption(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-// 	if len(args) != 1 {
-// 		return shim.Error("Incorrect number of arguments. Expecting 1")
-// 	}
-
-// 	key := args[0]
-// 	encoded, err := stub.GetState(KEY_PREFIX + key)
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-// 	pubKey, err := stub.GetState(KEY_PUBLIC)
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-// 	privKey, err := stub.GetState(KEY_PRIVATE)
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-// 	helper, errs := crypto.NewRSAHelper(pubKey, privKey)
-// 	if errs != nil && len(errs) > 0 {
-// 		return shim.Error(errs[0].Error())
-// 	}
-// 	decoded, err := helper.Decrypt(string(encoded))
-// 	if err != nil {
-// 		return shim.Error(err.Error())
-// 	}
-
-// 	return shim.Success([]byte(decoded))
-// }
-
-func (t *BalanceManager) sendEvent(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// parsePageSize - parse page size between 1 and MAX_PAGE_SIZE
+func parsePageSize(val string) (int32, error) {
+	pageSize, err := strconv.Atoi(val)
+	if err != nil || pageSize <= 0 || pageSize > MAX_PAGE_SIZE {
+		return 0, fmt.Errorf(`Invalid page size, expecting an integer between 1 and %d. (actual: "%s")`, MAX_PAGE_SIZE, val)
 	}
+	return int32(pageSize), nil
+}
 
-	eventName := args[0]
-	message := args[1]
+// parseAmount - parse transaction amount with decimals of asset, only positive value is accepted
+func parseAmount(val string, asset *Asset) (Amount, error) {
+	amount, err := ParseAmount(val, asset.Decimals)
+	if err != nil {
+		return Amount{}, fmt.Errorf(`Invalid transaction amount, expecting a decimal value with at most %d decimals. (actual: "%s")`, asset.Decimals, val)
+	}
+	if amount.Sign() <= 0 {
+		return Amount{}, fmt.Errorf(`Invalid transaction amount, expecting a positive value. (actual: "%s")`, val)
+	}
+	return amount, nil
+}
 
-	err := stub.SetEvent(eventName, []byte(message))
+// loadAsset - load registered asset, the error response is returned if not found
+func loadAsset(stub shim.ChaincodeStubInterface, assetCode string) (*Asset, pb.Response) {
+	asset, err := getAsset(stub, assetCode)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errorResponse(ERR_LEDGER, fmt.Sprintf(`Failed to get asset "%s". cause: (%s)`, assetCode, err))
+	}
+	if asset == nil {
+		return nil, errorResponse(ERR_ASSET_NOT_FOUND, fmt.Sprintf(`Asset not registered. (Asset: "%s")`, assetCode))
 	}
+	return asset, shim.Success(nil)
+}
 
-	return shim.Success(nil)
+// loadBalance - load account and its balance of asset, the error response is returned if either not found
+func loadBalance(stub shim.ChaincodeStubInterface, accountName string, asset *Asset) (*Account, *Balance, pb.Response) {
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return nil, nil, errorResponse(ERR_LEDGER, fmt.Sprintf(`Failed to get state for "%s". cause: (%s)`, accountName, err))
+	}
+	if account == nil {
+		return nil, nil, errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	balance, resp := accountBalance(account, asset)
+	if balance == nil {
+		return nil, nil, resp
+	}
+	return account, balance, shim.Success(nil)
 }
 
-func (t *BalanceManager) putPrivateData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+// accountBalance - balance of asset held by account rescaled to decimals of asset,
+// the error response is returned if not opened or the stored value does not fit
+func accountBalance(account *Account, asset *Asset) (*Balance, pb.Response) {
+	balance := account.GetBalance(asset.Code)
+	if balance == nil {
+		return nil, errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s", Asset: "%s")`, account.Name, asset.Code))
+	}
+	err := balance.Normalize(asset.Decimals)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, fmt.Sprintf(`Corrupted balance. (Account: "%s", Asset: "%s", cause: %s)`, account.Name, asset.Code, err))
 	}
+	return balance, shim.Success(nil)
+}
 
-	col := args[0]
-	key := args[1]
-	val := args[2]
+// cachedBalance - load account through cache and its balance of asset, the error response is returned if either not found
+func cachedBalance(cache *accountCache, accountName string, asset *Asset) (*Account, *Balance, pb.Response) {
+	account, err := cache.getAccount(accountName)
+	if err != nil {
+		return nil, nil, errorResponse(ERR_LEDGER, fmt.Sprintf(`Failed to get state for "%s". cause: (%s)`, accountName, err))
+	}
+	if account == nil {
+		return nil, nil, errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	balance, resp := accountBalance(account, asset)
+	if balance == nil {
+		return nil, nil, resp
+	}
+	return account, balance, shim.Success(nil)
+}
+
+// insufficientFunds - error response of balance which can not cover amount
+func insufficientFunds(accountName string, assetCode string, balance *Balance, amount Amount) pb.Response {
+	return errorResponse(ERR_INSUFFICIENT_FUNDS, fmt.Sprintf(`Insufficient funds. (Account: "%s", asset: "%s", balance: %s, credit limit: %s, amount: %s)`,
+		accountName, assetCode, balance.Amount, balance.CreditLimit, amount))
+}
 
-	err := stub.PutPrivateData(col, key, []byte(val))
+// query: query account balance
+func (t *BalanceManager) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var A string // Entities
+	var err error
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting name of the person to query and optional asset code")
+	}
+
+	A = args[0]
+
+	// Get the state from the ledger
+	account, err := getAccount(stub, A)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, fmt.Sprintf(`Failed to get state for "%s". cause: (%s)`, A, err))
+	}
+
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, A))
+	}
+
+	var view interface{} = account
+	if len(args) == 2 {
+		asset, resp := loadAsset(stub, args[1])
+		if asset == nil {
+			return resp
+		}
+		balance, resp := accountBalance(account, asset)
+		if balance == nil {
+			return resp
+		}
+		balanceView := NewBalanceView(account, asset.Code, balance)
+		rate, _, err := interestTerms(stub, asset.Code, account.Name)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if rate != nil {
+			now, err := txTime(stub)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			interest := pendingInterest(balance, rate, now, asset.Decimals)
+			balanceView.Interest = &interest
+		}
+		view = balanceView
+	}
+
+	Avalbytes, err := json.Marshal(view)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	fmt.Printf("Query Response:%s\n", string(Avalbytes))
+	fmt.Println()
 
-	return shim.Success(nil)
+	return shim.Success(Avalbytes)
 }
 
-func (t *BalanceManager) getPrivateData(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *BalanceManager) put(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	col := args[0]
-	key := args[1]
-	val, err := stub.GetPrivateData(col, key)
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to put raw value.")
+	}
+
+	key := args[0]
+	val := args[1]
+	// documents and indexes live under composite keys, which raw values must not overwrite
+	if key == "" || key[0] == 0x00 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Raw value under an empty or composite key is not allowed.")
+	}
+
+	err := stub.PutState(key, []byte(val))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_STATE_PUT, StateEvent{Key: key})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (t *BalanceManager) get(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to get raw value.")
+	}
+
+	key := args[0]
+	val, err := stub.GetState(key)
 
 	if err != nil {
 		return shim.Error(err.Error())
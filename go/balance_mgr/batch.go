@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// MAX_BATCH_LEGS - maximum legs of one batch transfer
+	MAX_BATCH_LEGS = 1000
+)
+
+// BatchLeg - one credit of a batch transfer, fee and collector are set by the chaincode for the event
+type BatchLeg struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Asset     string `json:"asset"`
+	Amount    string `json:"amount"`
+	Memo      string `json:"memo,omitempty"`
+	Fee       string `json:"fee,omitempty"`
+	Collector string `json:"collector,omitempty"`
+}
+
+// batchTransfer: apply a JSON list of transfer legs atomically. Every source account must be owned
+// by the creator and cover its total debits per asset, fees of every leg included, before any leg is
+// applied; signer approval and spending limits are checked against the total of the amounts.
+func (t *BalanceManager) batchTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("batch transfer")
+
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	legs := make([]*BatchLeg, 0)
+	err := json.Unmarshal([]byte(args[0]), &legs)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid legs, expecting a JSON list. cause: (%s)", err))
+	}
+	if len(legs) == 0 || len(legs) > MAX_BATCH_LEGS {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid number of legs, expecting 1 to %d. (actual: %d)", MAX_BATCH_LEGS, len(legs)))
+	}
+
+	cache := newAccountCache(stub)
+	amounts := make([]Amount, len(legs))
+	fees := make([]Amount, len(legs))
+	collectors := make([]*Account, len(legs))
+	schedules := make(map[string]*FeeSchedule)
+	debits := make(map[string]map[string]Amount)
+	charges := make(map[string]map[string]Amount)
+	authorized := make(map[string]bool)
+	for idx, leg := range legs {
+		if leg.From == leg.To {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Transfer to the same account is not allowed. (leg: %d, Account: "%s")`, idx, leg.From))
+		}
+		asset, err := cache.getAsset(leg.Asset)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if asset == nil {
+			return errorResponse(ERR_ASSET_NOT_FOUND, fmt.Sprintf(`Asset not registered. (leg: %d, Asset: "%s")`, idx, leg.Asset))
+		}
+		amounts[idx], err = parseAmount(leg.Amount, asset)
+		if err != nil {
+			return errorResponse(ERR_INVALID_AMOUNT, fmt.Sprintf("%s (leg: %d)", err.Error(), idx))
+		}
+
+		for _, accountName := range []string{leg.From, leg.To} {
+			account, err := cache.getAccount(accountName)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			if account == nil {
+				return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (leg: %d, Account: "%s")`, idx, accountName))
+			}
+			balance, resp := accountBalance(account, asset)
+			if balance == nil {
+				return resp
+			}
+			if resp, inactive := notActive(account); inactive {
+				return resp
+			}
+		}
+
+		if !authorized[leg.From] {
+			from, _ := cache.getAccount(leg.From)
+			err = authorizeOwner(stub, from)
+			if err != nil {
+				return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("%s (leg: %d)", err.Error(), idx))
+			}
+			authorized[leg.From] = true
+		}
+
+		// the fee of each leg is paid on top by the sender, as by transfer
+		schedule, ok := schedules[leg.Asset]
+		if !ok {
+			schedule, err = getFeeSchedule(stub, leg.Asset)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			schedules[leg.Asset] = schedule
+		}
+		fees[idx] = ZeroAmount(asset.Decimals)
+		if schedule != nil && schedule.Collector != leg.From {
+			fees[idx] = schedule.Compute(amounts[idx])
+			collector, _, resp := cachedBalance(cache, schedule.Collector, asset)
+			if collector == nil {
+				payload := parseErrorPayload(resp)
+				return errorResponse(payload.Code, fmt.Sprintf("%s (leg: %d)", payload.Message, idx))
+			}
+			if resp, inactive := notActive(collector); inactive {
+				return resp
+			}
+			collectors[idx] = collector
+		}
+
+		if debits[leg.From] == nil {
+			debits[leg.From] = make(map[string]Amount)
+			charges[leg.From] = make(map[string]Amount)
+		}
+		debits[leg.From][leg.Asset] = amounts[idx].Add(debits[leg.From][leg.Asset])
+		charges[leg.From][leg.Asset] = amounts[idx].Add(fees[idx]).Add(charges[leg.From][leg.Asset])
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	accrued := make(map[string]bool)
+	for idx, leg := range legs {
+		asset, _ := cache.getAsset(leg.Asset)
+		names := []string{leg.From, leg.To}
+		if collectors[idx] != nil {
+			names = append(names, collectors[idx].Name)
+		}
+		for _, accountName := range names {
+			if accrued[accountName+"\x00"+leg.Asset] {
+				continue
+			}
+			account, _ := cache.getAccount(accountName)
+			resp := accrueTouched(stub, cache, journal, asset, account)
+			if resp.Status != shim.OK {
+				return resp
+			}
+			accrued[accountName+"\x00"+leg.Asset] = true
+		}
+	}
+
+	// every source must cover its total debits without counting credits of the same batch, and approval
+	// and spending limits apply to the total, so that splitting an amount into legs does not evade them
+	for idx, leg := range legs {
+		total, ok := debits[leg.From][leg.Asset]
+		if !ok {
+			continue
+		}
+		asset, _ := cache.getAsset(leg.Asset)
+		from, _ := cache.getAccount(leg.From)
+		balance := from.GetBalance(leg.Asset)
+		charge := charges[leg.From][leg.Asset]
+		if !balance.CanDebit(charge) {
+			return errorResponse(ERR_INSUFFICIENT_FUNDS, fmt.Sprintf(`Insufficient funds for total debits. (leg: %d, Account: "%s", asset: "%s", balance: %s, credit limit: %s, total: %s)`,
+				idx, leg.From, leg.Asset, balance.Amount, balance.CreditLimit, charge))
+		}
+		resp := requireApproval(stub, leg.From, asset, total)
+		if resp.Status != shim.OK {
+			payload := parseErrorPayload(resp)
+			return errorResponse(payload.Code, fmt.Sprintf("%s (leg: %d)", payload.Message, idx))
+		}
+		resp = cache.spending.spend(from, asset, total)
+		if resp.Status != shim.OK {
+			payload := parseErrorPayload(resp)
+			return errorResponse(payload.Code, fmt.Sprintf("%s (leg: %d)", payload.Message, idx))
+		}
+		delete(debits[leg.From], leg.Asset)
+	}
+
+	for idx, leg := range legs {
+		from, _ := cache.getAccount(leg.From)
+		to, _ := cache.getAccount(leg.To)
+		balanceFrom := from.GetBalance(leg.Asset)
+		balanceTo := to.GetBalance(leg.Asset)
+
+		balanceFrom.Amount = balanceFrom.Amount.Sub(amounts[idx])
+		balanceTo.Amount = balanceTo.Amount.Add(amounts[idx])
+
+		err = journal.Append(ENTRY_TRANSFER_OUT, leg.From, leg.Asset, leg.To, amounts[idx].Neg(), balanceFrom.Amount, leg.Memo)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = journal.Append(ENTRY_TRANSFER_IN, leg.To, leg.Asset, leg.From, amounts[idx], balanceTo.Amount, leg.Memo)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		leg.Amount = amounts[idx].String()
+		leg.Fee, leg.Collector = "", ""
+
+		if fees[idx].Sign() > 0 {
+			collector := collectors[idx]
+			balanceCollector := collector.GetBalance(leg.Asset)
+			balanceFrom.Amount = balanceFrom.Amount.Sub(fees[idx])
+			err = journal.Append(ENTRY_FEE, leg.From, leg.Asset, collector.Name, fees[idx].Neg(), balanceFrom.Amount, leg.Memo)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			balanceCollector.Amount = balanceCollector.Amount.Add(fees[idx])
+			err = journal.Append(ENTRY_FEE_IN, collector.Name, leg.Asset, leg.From, fees[idx], balanceCollector.Amount, leg.Memo)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			leg.Fee, leg.Collector = fees[idx].String(), collector.Name
+		}
+	}
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_BATCH_TRANSFERRED, BatchTransferEvent{Legs: legs})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
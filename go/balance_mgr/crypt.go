@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chaincodes/common/crypto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// TRANSIENT_KEY_PREFIX - transient field of the AES key of a version, e.g. 'ENC_KEY_2'
+	TRANSIENT_KEY_PREFIX = "ENC_KEY_"
+	// TRANSIENT_KEY_VERSION - transient field naming the key version used for encryption
+	TRANSIENT_KEY_VERSION = "ENC_KEY_VERSION"
+	// CIPHER_TAG - prefix of ciphertext stored in documents, 'enc:<version>:<hex>'
+	CIPHER_TAG = "enc"
+
+	FIELD_KYC_REF = "kyc_ref"
+	FIELD_MEMO    = "memo"
+)
+
+// AccountMetadata - decrypted sensitive metadata of account
+type AccountMetadata struct {
+	Account     string   `json:"account"`
+	KYCRef      string   `json:"kyc_ref,omitempty"`
+	Memo        string   `json:"memo,omitempty"`
+	KeyVersions []string `json:"key_versions"`
+}
+
+// fieldCipher - AES keys supplied through the transient map of the proposal, so that neither keys
+// nor plaintext are written to the ledger
+type fieldCipher struct {
+	txID    string
+	version string
+	keys    map[string]string
+}
+
+func newFieldCipher(stub shim.ChaincodeStubInterface) (*fieldCipher, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	cipher := fieldCipher{txID: stub.GetTxID(), version: string(transient[TRANSIENT_KEY_VERSION]), keys: make(map[string]string)}
+	for name, val := range transient {
+		if strings.HasPrefix(name, TRANSIENT_KEY_PREFIX) && name != TRANSIENT_KEY_VERSION {
+			cipher.keys[strings.TrimPrefix(name, TRANSIENT_KEY_PREFIX)] = string(val)
+		}
+	}
+	return &cipher, nil
+}
+
+func (t *fieldCipher) helper(version string) (*crypto.AESHelper, error) {
+	key, ok := t.keys[version]
+	if !ok {
+		return nil, fmt.Errorf(`key of version not supplied. (transient: "%s%s")`, TRANSIENT_KEY_PREFIX, version)
+	}
+	return crypto.NewAESHelper(key)
+}
+
+// Encrypt - encrypt field with the current key version. The iv is derived from key, transaction
+// and field, so that every endorser writes the same ciphertext.
+func (t *fieldCipher) Encrypt(field string, plaintext string) (string, error) {
+	if t.version == "" || strings.Contains(t.version, ":") {
+		return "", fmt.Errorf(`invalid key version for encryption. (transient: "%s", actual: "%s")`, TRANSIENT_KEY_VERSION, t.version)
+	}
+	helper, err := t.helper(t.version)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(t.keys[t.version] + "\x00" + t.txID + "\x00" + field))
+	encoded, err := helper.EncryptWithIV(plaintext, digest[:16])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{CIPHER_TAG, t.version, encoded}, ":"), nil
+}
+
+// Decrypt - decrypt tagged ciphertext with the key of its version
+func (t *fieldCipher) Decrypt(ciphertext string) (string, error) {
+	version, encoded, err := parseCipherTag(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	helper, err := t.helper(version)
+	if err != nil {
+		return "", err
+	}
+	return helper.Decrypt(encoded)
+}
+
+// parseCipherTag - key version and hex ciphertext of tagged ciphertext
+func parseCipherTag(ciphertext string) (string, string, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != CIPHER_TAG || parts[1] == "" {
+		return "", "", fmt.Errorf(`invalid ciphertext, expecting "%s:<version>:<hex>"`, CIPHER_TAG)
+	}
+	return parts[1], parts[2], nil
+}
+
+// metadataFields - encrypted metadata fields of account by field name
+func metadataFields(account *Account) map[string]*string {
+	return map[string]*string{FIELD_KYC_REF: &account.KYCRef, FIELD_MEMO: &account.Memo}
+}
+
+// loadMetadataAccount - load account whose metadata the creator may access (owner or admin)
+func loadMetadataAccount(stub shim.ChaincodeStubInterface, accountName string) (*Account, pb.Response) {
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return nil, errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if !isAdmin(stub) {
+		err = authorizeOwner(stub, account)
+		if err != nil {
+			return nil, errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+	return account, shim.Success(nil)
+}
+
+// setMetadata: encrypt metadata fields given in the transient map ('kyc_ref', 'memo') into account
+// (owner or admin). Fields missing from the transient map are kept as they are.
+func (t *BalanceManager) setMetadata(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.encryptMetadata(stub, args, false)
+}
+
+// rotateMetadata: re-encrypt every metadata field of account with the current key version (owner or admin)
+func (t *BalanceManager) rotateMetadata(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.encryptMetadata(stub, args, true)
+}
+
+func (t *BalanceManager) encryptMetadata(stub shim.ChaincodeStubInterface, args []string, rotate bool) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	account, resp := loadMetadataAccount(stub, args[0])
+	if account == nil {
+		return resp
+	}
+	if account.Status == STATUS_CLOSED {
+		return errorResponse(ERR_ACCOUNT_CLOSED, fmt.Sprintf(`Account is closed. (Account: "%s")`, account.Name))
+	}
+
+	cipher, err := newFieldCipher(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	changed := make([]string, 0)
+	for name, field := range metadataFields(account) {
+		plaintext, ok := transient[name]
+		if rotate {
+			if *field == "" {
+				continue
+			}
+			decrypted, err := cipher.Decrypt(*field)
+			if err != nil {
+				return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Failed to decrypt "%s". cause: (%s)`, name, err))
+			}
+			plaintext, ok = []byte(decrypted), true
+		}
+		if !ok {
+			continue
+		}
+		encrypted, err := cipher.Encrypt(name, string(plaintext))
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Failed to encrypt "%s". cause: (%s)`, name, err))
+		}
+		*field = encrypted
+		changed = append(changed, name)
+	}
+	if len(changed) == 0 {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`No metadata field to encrypt, expecting transient "%s" or "%s".`, FIELD_KYC_REF, FIELD_MEMO))
+	}
+	sort.Strings(changed)
+
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_METADATA_UPDATED, MetadataEvent{Account: account.Name, Fields: changed, KeyVersion: cipher.version})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getMetadata: decrypt metadata of account with the keys of the transient map (owner or admin)
+func (t *BalanceManager) getMetadata(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	account, resp := loadMetadataAccount(stub, args[0])
+	if account == nil {
+		return resp
+	}
+	cipher, err := newFieldCipher(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	metadata := AccountMetadata{Account: account.Name, KeyVersions: make([]string, 0)}
+	decrypted := map[string]*string{FIELD_KYC_REF: &metadata.KYCRef, FIELD_MEMO: &metadata.Memo}
+	versions := make(map[string]bool)
+	for name, field := range metadataFields(account) {
+		if *field == "" {
+			continue
+		}
+		plaintext, err := cipher.Decrypt(*field)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Failed to decrypt "%s". cause: (%s)`, name, err))
+		}
+		*decrypted[name] = plaintext
+		version, _, _ := parseCipherTag(*field)
+		versions[version] = true
+	}
+	for version := range versions {
+		metadata.KeyVersions = append(metadata.KeyVersions, version)
+	}
+	sort.Strings(metadata.KeyVersions)
+
+	bytes, err := json.Marshal(metadata)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FieldCipher(t *testing.T) {
+	cipher := fieldCipher{txID: "tx1", version: "2", keys: map[string]string{
+		"1": "0123456789abcdef",
+		"2": "fedcba9876543210fedcba9876543210",
+	}}
+
+	encrypted, err := cipher.Encrypt(FIELD_KYC_REF, "KYC-0001")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "enc:2:"))
+	assert.NotContains(t, encrypted, "KYC-0001")
+
+	// every endorser derives the same ciphertext, but fields do not share it
+	again, _ := cipher.Encrypt(FIELD_KYC_REF, "KYC-0001")
+	assert.Equal(t, encrypted, again)
+	other, _ := cipher.Encrypt(FIELD_MEMO, "KYC-0001")
+	assert.NotEqual(t, encrypted, other)
+
+	decrypted, err := cipher.Decrypt(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "KYC-0001", decrypted)
+
+	// ciphertext of a rotated-out version needs its key
+	old := fieldCipher{txID: "tx0", version: "1", keys: map[string]string{"1": "0123456789abcdef"}}
+	legacy, err := old.Encrypt(FIELD_MEMO, "memo")
+	assert.Nil(t, err)
+	decrypted, err = cipher.Decrypt(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, "memo", decrypted)
+	_, err = old.Decrypt(encrypted)
+	assert.NotNil(t, err)
+
+	_, err = cipher.Decrypt("KYC-0001")
+	assert.NotNil(t, err)
+	_, err = (&fieldCipher{keys: cipher.keys}).Encrypt(FIELD_MEMO, "memo")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	INDEX_ACCOUNT = "account"
+	INDEX_OWNER   = "owner~account"
+	INDEX_ASSET   = "asset"
+	INDEX_SUPPLY  = "supply"
+
+	// CONFIG_ACCOUNTS_MIGRATED - config entry marking that accounts were moved off simple keys
+	CONFIG_ACCOUNTS_MIGRATED = "accounts_migrated"
+
+	// TIMESTAMP_FORMAT - fixed width RFC3339 in UTC, so that timestamps sort as strings
+	TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000000000Z"
+)
+
+// accountKey - composite key of account document, keeping accounts apart from raw values written by put
+func accountKey(stub shim.ChaincodeStubInterface, accountName string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_ACCOUNT, []string{accountName})
+}
+
+// getAccount - load account document, nil if account not existing
+func getAccount(stub shim.ChaincodeStubInterface, accountName string) (*Account, error) {
+	key, err := accountKey(stub, accountName)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseAccount(valBytes)
+}
+
+// putAccount - stamp account with current transaction and write it back to ledger
+func putAccount(stub shim.ChaincodeStubInterface, account *Account) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	account.Touch(stub.GetTxID(), timestamp)
+
+	key, err := accountKey(stub, account.Name)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// indexOwner - add account to the index of its owner, accounts without owner are not indexed
+func indexOwner(stub shim.ChaincodeStubInterface, account *Account) error {
+	if account.Owner.MSPID == "" {
+		return nil
+	}
+	key, err := stub.CreateCompositeKey(INDEX_OWNER, []string{account.Owner.MSPID, account.Owner.ID, account.Name})
+	if err != nil {
+		return err
+	}
+	// an empty value would delete the key
+	return stub.PutState(key, []byte{0x00})
+}
+
+// unindexOwner - remove account from the index of its owner
+func unindexOwner(stub shim.ChaincodeStubInterface, account *Account) error {
+	if account.Owner.MSPID == "" {
+		return nil
+	}
+	key, err := stub.CreateCompositeKey(INDEX_OWNER, []string{account.Owner.MSPID, account.Owner.ID, account.Name})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// txTimestamp - transaction timestamp formatted with TIMESTAMP_FORMAT
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return tm.Format(TIMESTAMP_FORMAT), nil
+}
+
+// txTime - transaction timestamp in UTC
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	tm, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tm.UTC(), nil
+}
+
+// creatorIdentity - identity of transaction creator
+func creatorIdentity(stub shim.ChaincodeStubInterface) (Identity, error) {
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return Identity{}, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{ID: id, MSPID: mspID}, nil
+}
+
+// getAsset - load asset document, nil if asset not registered
+func getAsset(stub shim.ChaincodeStubInterface, assetCode string) (*Asset, error) {
+	key, err := stub.CreateCompositeKey(INDEX_ASSET, []string{assetCode})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseAsset(valBytes)
+}
+
+// putAsset - stamp asset with current transaction and write it to ledger
+func putAsset(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	key, err := stub.CreateCompositeKey(INDEX_ASSET, []string{asset.Code})
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	asset.CreatedTx = stub.GetTxID()
+	asset.CreatedAt = timestamp
+
+	bytes, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// getSupply - load supply document of asset, nil if not existing
+func getSupply(stub shim.ChaincodeStubInterface, assetCode string) (*Supply, error) {
+	key, err := stub.CreateCompositeKey(INDEX_SUPPLY, []string{assetCode})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseSupply(valBytes)
+}
+
+// putSupply - stamp supply with current transaction and write it to ledger
+func putSupply(stub shim.ChaincodeStubInterface, supply *Supply) error {
+	key, err := stub.CreateCompositeKey(INDEX_SUPPLY, []string{supply.Asset})
+	if err != nil {
+		return err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	supply.UpdatedTx = stub.GetTxID()
+	supply.UpdatedAt = timestamp
+
+	bytes, err := json.Marshal(supply)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// listAssets - every registered asset ordered by code
+func listAssets(stub shim.ChaincodeStubInterface) ([]*Asset, error) {
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_ASSET, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultIt.Close()
+
+	assets := make([]*Asset, 0)
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return nil, err
+		}
+		asset, err := ParseAsset(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// accountsMigrated - check whether the marker of the one-shot account migration is committed
+func accountsMigrated(stub shim.ChaincodeStubInterface) (bool, error) {
+	key, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_ACCOUNTS_MIGRATED})
+	if err != nil {
+		return false, err
+	}
+	marker, err := stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return len(marker) > 0, nil
+}
+
+// scanAccounts - call fn with every account document. Documents still stored under simple keys by
+// older versions are included until the migration is committed, so that upgrade can count them
+// before they are moved.
+func scanAccounts(stub shim.ChaincodeStubInterface, fn func(account *Account) error) error {
+	migrated, err := accountsMigrated(stub)
+	if err != nil {
+		return err
+	}
+	for _, namespaced := range []bool{true, false} {
+		if migrated && !namespaced {
+			break
+		}
+		var resultIt shim.StateQueryIteratorInterface
+		var err error
+		if namespaced {
+			resultIt, err = stub.GetStateByPartialCompositeKey(INDEX_ACCOUNT, []string{})
+		} else {
+			resultIt, err = stub.GetStateByRange("", "")
+		}
+		if err != nil {
+			return err
+		}
+		defer resultIt.Close()
+
+		for resultIt.HasNext() {
+			kv, err := resultIt.Next()
+			if err != nil {
+				return err
+			}
+			doc := AbstractDoc{}
+			if json.Unmarshal(kv.Value, &doc) != nil || doc.DocType != DOC_ACCOUNT {
+				continue
+			}
+			account, err := ParseAccount(kv.Value)
+			if err != nil {
+				return err
+			}
+			err = fn(account)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sumBalances - total of every account balance per asset
+func sumBalances(stub shim.ChaincodeStubInterface) (map[string]Amount, error) {
+	totals := make(map[string]Amount)
+	err := scanAccounts(stub, func(account *Account) error {
+		for assetCode, balance := range account.Balances {
+			totals[assetCode] = balance.Amount.Add(totals[assetCode])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// seedSupplies - create supply documents of assets registered before supply was tracked,
+// taking the sum of account balances as issued amount. Must run after migrateAccounts, since
+// legacy integer-string balances are not counted.
+func seedSupplies(stub shim.ChaincodeStubInterface) (int, error) {
+	assets, err := listAssets(stub)
+	if err != nil {
+		return 0, err
+	}
+	var totals map[string]Amount
+	seeded := 0
+	for _, asset := range assets {
+		supply, err := getSupply(stub, asset.Code)
+		if err != nil {
+			return 0, err
+		}
+		if supply != nil {
+			continue
+		}
+		if totals == nil {
+			totals, err = sumBalances(stub)
+			if err != nil {
+				return 0, err
+			}
+		}
+		supply = NewSupply(asset.Code, asset.Decimals, nil)
+		if total, ok := totals[asset.Code]; ok {
+			supply.Issued, err = total.Rescale(asset.Decimals)
+			if err != nil {
+				return 0, fmt.Errorf(`invalid balances total. (asset: "%s", cause: %s)`, asset.Code, err)
+			}
+		}
+		err = putSupply(stub, supply)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Printf(`Supply of asset seeded. (asset: "%s", issued: %s)`, asset.Code, supply.Issued)
+		fmt.Println()
+		seeded++
+	}
+	return seeded, nil
+}
+
+// migrateAccounts - move bare integer-string balances and account documents stored under simple keys
+// to namespaced keys as current account documents. The endorsement policy of the simple key is
+// carried over, accounts without one get their owner org stamped, and owners are indexed.
+// The migration runs once, recorded by a config marker, so that values written later by 'put' are
+// never taken for accounts. A simple key whose name already has a namespaced account is left alone.
+func migrateAccounts(stub shim.ChaincodeStubInterface) (int, error) {
+	migrated, err := accountsMigrated(stub)
+	if err != nil {
+		return 0, err
+	}
+	if migrated {
+		fmt.Println("Accounts already migrated.")
+		return 0, nil
+	}
+
+	resultIt, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer resultIt.Close()
+
+	legacies := make(map[string]bool)
+	accounts := make([]*Account, 0)
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return 0, err
+		}
+		if strings.HasPrefix(kv.Key, KEY_PREFIX_CREDIT) || strings.HasPrefix(kv.Key, KEY_PREFIX) || strings.HasPrefix(kv.Key, KEY_PREFIX_JOURNAL) {
+			continue
+		}
+		if balance, ok := ParseLegacyBalance(kv.Value); ok {
+			account := NewAccount(kv.Key, Identity{})
+			account.OpenBalance(DEFAULT_CURRENCY, 0).Amount = balance
+			accounts = append(accounts, account)
+			legacies[account.Name] = true
+			continue
+		}
+		doc := AbstractDoc{}
+		if json.Unmarshal(kv.Value, &doc) != nil || doc.DocType != DOC_ACCOUNT {
+			continue
+		}
+		account, err := ParseAccount(kv.Value)
+		if err != nil {
+			return 0, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	issuerMSP, err := cid.GetMSPID(stub)
+	if err != nil {
+		return 0, err
+	}
+	assets := make(map[string]*Asset)
+	supplies := make(map[string]*Supply)
+	count := 0
+	for _, account := range accounts {
+		existing, err := getAccount(stub, account.Name)
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			fmt.Printf(`Simple key skipped, account already migrated. (account: "%s")`, account.Name)
+			fmt.Println()
+			continue
+		}
+		if legacies[account.Name] {
+			err = migrateCreditLimit(stub, account)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		for assetCode, balance := range account.Balances {
+			asset, ok := assets[assetCode]
+			if !ok {
+				asset, err = getAsset(stub, assetCode)
+				if err != nil {
+					return 0, err
+				}
+				if asset == nil {
+					asset = NewAsset(assetCode, 0, issuerMSP)
+					err = putAsset(stub, asset)
+					if err != nil {
+						return 0, err
+					}
+					fmt.Printf(`Asset of migrated account registered. (asset: "%s", issuer: "%s")`, assetCode, issuerMSP)
+					fmt.Println()
+					supplies[assetCode] = NewSupply(assetCode, 0, nil)
+				} else {
+					// an asset without supply is seeded from balances by seedSupplies
+					supply, err := getSupply(stub, assetCode)
+					if err != nil {
+						return 0, err
+					}
+					if supply != nil {
+						supplies[assetCode] = supply
+					}
+				}
+				assets[assetCode] = asset
+			}
+			err = balance.Normalize(asset.Decimals)
+			if err != nil {
+				return 0, fmt.Errorf(`invalid balance. (account: "%s", asset: "%s", cause: %s)`, account.Name, assetCode, err)
+			}
+			if supply, ok := supplies[assetCode]; ok {
+				supply.Issued = supply.Issued.Add(balance.Amount)
+			}
+		}
+
+		err = putAccount(stub, account)
+		if err != nil {
+			return 0, err
+		}
+		err = moveAccountPolicy(stub, account)
+		if err != nil {
+			return 0, err
+		}
+		err = stub.DelState(account.Name)
+		if err != nil {
+			return 0, err
+		}
+		err = indexOwner(stub, account)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Printf(`Account migrated. (account: "%s")`, account.Name)
+		fmt.Println()
+		count++
+	}
+
+	// assets registered here are not visible to seedSupplies within the same transaction,
+	// so their supply is taken from the migrated balances
+	// and the supply of existing assets grows by the migrated balances
+	for _, supply := range supplies {
+		err = putSupply(stub, supply)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return 0, err
+	}
+	markerKey, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_ACCOUNTS_MIGRATED})
+	if err != nil {
+		return 0, err
+	}
+	err = stub.PutState(markerKey, []byte(timestamp))
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// migrateCreditLimit - fold credit limit kept beside legacy balance into account document
+func migrateCreditLimit(stub shim.ChaincodeStubInterface, account *Account) error {
+	creditKey := KEY_PREFIX_CREDIT + account.Name
+	creditBytes, err := stub.GetState(creditKey)
+	if err != nil {
+		return err
+	}
+	if len(creditBytes) == 0 {
+		return nil
+	}
+	creditLimit, ok := ParseLegacyBalance(creditBytes)
+	if !ok {
+		return fmt.Errorf(`invalid legacy credit limit. (account: "%s", value: "%s")`, account.Name, string(creditBytes))
+	}
+	account.GetBalance(DEFAULT_CURRENCY).CreditLimit = creditLimit
+	return stub.DelState(creditKey)
+}
+
+// accountCache - accounts loaded within one transaction. GetState does not see writes of the
+// current transaction, so invokes touching an account more than once must go through the cache
+// and flush it once at the end. Outgoing amounts are counted against spending limits alongside.
+type accountCache struct {
+	stub     shim.ChaincodeStubInterface
+	accounts map[string]*Account
+	assets   map[string]*Asset
+	order    []string
+	spending *spendingTracker
+}
+
+func newAccountCache(stub shim.ChaincodeStubInterface) *accountCache {
+	return &accountCache{
+		stub:     stub,
+		accounts: make(map[string]*Account),
+		assets:   make(map[string]*Asset),
+		order:    make([]string, 0),
+		spending: newSpendingTracker(stub),
+	}
+}
+
+// getAccount - cached account, nil if account not existing
+func (t *accountCache) getAccount(accountName string) (*Account, error) {
+	if account, ok := t.accounts[accountName]; ok {
+		return account, nil
+	}
+	account, err := getAccount(t.stub, accountName)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	t.accounts[accountName] = account
+	t.order = append(t.order, accountName)
+	return account, nil
+}
+
+// getAsset - cached asset, nil if asset not registered
+func (t *accountCache) getAsset(assetCode string) (*Asset, error) {
+	if asset, ok := t.assets[assetCode]; ok {
+		return asset, nil
+	}
+	asset, err := getAsset(t.stub, assetCode)
+	if err != nil || asset == nil {
+		return nil, err
+	}
+	t.assets[assetCode] = asset
+	return asset, nil
+}
+
+// flush - write every loaded account back to ledger in loading order, then the counted usage
+func (t *accountCache) flush() error {
+	for _, accountName := range t.order {
+		err := putAccount(t.stub, t.accounts[accountName])
+		if err != nil {
+			return err
+		}
+	}
+	return t.spending.flush()
+}
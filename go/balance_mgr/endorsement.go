@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AccountOrgs - orgs whose peers must endorse every update of account, also payload of EndorsementChanged
+type AccountOrgs struct {
+	Account string   `json:"account"`
+	Orgs    []string `json:"orgs"`
+}
+
+// accountPolicy - key-level endorsement policy of account, empty if never stamped
+func accountPolicy(stub shim.ChaincodeStubInterface, accountName string) (statebased.KeyEndorsementPolicy, error) {
+	key, err := accountKey(stub, accountName)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := stub.GetStateValidationParameter(key)
+	if err != nil {
+		return nil, err
+	}
+	return statebased.NewStateEP(policy)
+}
+
+// setAccountPolicy - write key-level endorsement policy of account
+func setAccountPolicy(stub shim.ChaincodeStubInterface, accountName string, ep statebased.KeyEndorsementPolicy) error {
+	key, err := accountKey(stub, accountName)
+	if err != nil {
+		return err
+	}
+	policy, err := ep.Policy()
+	if err != nil {
+		return err
+	}
+	return stub.SetStateValidationParameter(key, policy)
+}
+
+// stampAccountPolicy - add orgs to key-level endorsement policy of account, so that its updates need
+// endorsement from peers of every org
+func stampAccountPolicy(stub shim.ChaincodeStubInterface, accountName string, orgs ...string) error {
+	ep, err := accountPolicy(stub, accountName)
+	if err != nil {
+		return err
+	}
+	err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+	if err != nil {
+		return err
+	}
+	return setAccountPolicy(stub, accountName, ep)
+}
+
+// moveAccountPolicy - carry endorsement policy of account stored under a simple key over to its
+// namespaced key, the owner org is stamped if the account never had one
+func moveAccountPolicy(stub shim.ChaincodeStubInterface, account *Account) error {
+	policy, err := stub.GetStateValidationParameter(account.Name)
+	if err != nil {
+		return err
+	}
+	if len(policy) == 0 {
+		if account.Owner.MSPID == "" {
+			return nil
+		}
+		return stampAccountPolicy(stub, account.Name, account.Owner.MSPID)
+	}
+	key, err := accountKey(stub, account.Name)
+	if err != nil {
+		return err
+	}
+	return stub.SetStateValidationParameter(key, policy)
+}
+
+// addAccountOrgs: add orgs to endorsement policy of account (admin only), <account> <mspID>...
+func (t *BalanceManager) addAccountOrgs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeAccountOrgs(stub, args, true)
+}
+
+// removeAccountOrgs: remove orgs from endorsement policy of account (admin only), <account> <mspID>...
+// At least one org must remain.
+func (t *BalanceManager) removeAccountOrgs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeAccountOrgs(stub, args, false)
+}
+
+// changeAccountOrgs - change endorsement policy of account. The change itself is validated
+// against the current policy, so it needs endorsement of the orgs already listed.
+func (t *BalanceManager) changeAccountOrgs(stub shim.ChaincodeStubInterface, args []string, add bool) pb.Response {
+	if len(args) < 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting at least 2")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to change account endorsement policy.")
+	}
+
+	accountName := args[0]
+	orgs := args[1:]
+	for _, org := range orgs {
+		if org == "" {
+			return errorResponse(ERR_INVALID_ARGUMENT, "MSP id must not be empty.")
+		}
+	}
+
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+
+	ep, err := accountPolicy(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if add {
+		err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+	} else {
+		ep.DelOrgs(orgs...)
+		if len(ep.ListOrgs()) == 0 {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Removing every org from endorsement policy is not allowed. (Account: "%s")`, accountName))
+		}
+	}
+	err = setAccountPolicy(stub, accountName, ep)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	listed := ep.ListOrgs()
+	sort.Strings(listed)
+	err = emitEvent(stub, EVENT_ENDORSEMENT_CHANGED, AccountOrgs{Account: accountName, Orgs: listed})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryAccountOrgs: query orgs of endorsement policy of account
+func (t *BalanceManager) queryAccountOrgs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	account, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, args[0]))
+	}
+
+	ep, err := accountPolicy(stub, account.Name)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	orgs := ep.ListOrgs()
+	sort.Strings(orgs)
+
+	bytes, err := json.Marshal(AccountOrgs{Account: account.Name, Orgs: orgs})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
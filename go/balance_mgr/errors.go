@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ErrorCode - machine readable error code returned to clients
+type ErrorCode string
+
+const (
+	ERR_INVALID_ARGUMENT    ErrorCode = "INVALID_ARGUMENT"
+	ERR_INVALID_AMOUNT      ErrorCode = "INVALID_AMOUNT"
+	ERR_ACCOUNT_NOT_FOUND   ErrorCode = "ACCOUNT_NOT_FOUND"
+	ERR_ACCOUNT_EXISTS      ErrorCode = "ACCOUNT_EXISTS"
+	ERR_ASSET_NOT_FOUND     ErrorCode = "ASSET_NOT_FOUND"
+	ERR_ASSET_EXISTS        ErrorCode = "ASSET_EXISTS"
+	ERR_INSUFFICIENT_FUNDS  ErrorCode = "INSUFFICIENT_FUNDS"
+	ERR_SUPPLY_EXCEEDED     ErrorCode = "SUPPLY_EXCEEDED"
+	ERR_ACCOUNT_FROZEN      ErrorCode = "ACCOUNT_FROZEN"
+	ERR_ACCOUNT_CLOSED      ErrorCode = "ACCOUNT_CLOSED"
+	ERR_INVALID_STATUS      ErrorCode = "INVALID_STATUS"
+	ERR_BALANCE_NOT_ZERO    ErrorCode = "BALANCE_NOT_ZERO"
+	ERR_HOLD_NOT_FOUND      ErrorCode = "HOLD_NOT_FOUND"
+	ERR_HOLD_EXPIRED        ErrorCode = "HOLD_EXPIRED"
+	ERR_FEE_NOT_FOUND       ErrorCode = "FEE_NOT_FOUND"
+	ERR_ALLOWANCE_NOT_FOUND ErrorCode = "ALLOWANCE_NOT_FOUND"
+	ERR_ALLOWANCE_EXPIRED   ErrorCode = "ALLOWANCE_EXPIRED"
+	ERR_ALLOWANCE_EXCEEDED  ErrorCode = "ALLOWANCE_EXCEEDED"
+	ERR_CREDIT_NOT_FOUND    ErrorCode = "CREDIT_NOT_FOUND"
+	ERR_DUPLICATE_REQUEST   ErrorCode = "DUPLICATE_REQUEST"
+	ERR_REQUEST_NOT_FOUND   ErrorCode = "REQUEST_NOT_FOUND"
+	ERR_ORDER_NOT_FOUND     ErrorCode = "ORDER_NOT_FOUND"
+	ERR_ALIAS_EXISTS        ErrorCode = "ALIAS_EXISTS"
+	ERR_ALIAS_NOT_FOUND     ErrorCode = "ALIAS_NOT_FOUND"
+	ERR_INTEREST_NOT_FOUND  ErrorCode = "INTEREST_NOT_FOUND"
+	ERR_LIMIT_EXCEEDED      ErrorCode = "LIMIT_EXCEEDED"
+	ERR_LIMIT_NOT_FOUND     ErrorCode = "LIMIT_NOT_FOUND"
+	ERR_APPROVAL_REQUIRED   ErrorCode = "APPROVAL_REQUIRED"
+	ERR_SIGNERS_NOT_FOUND   ErrorCode = "SIGNERS_NOT_FOUND"
+	ERR_PROPOSAL_NOT_FOUND  ErrorCode = "PROPOSAL_NOT_FOUND"
+	ERR_PROPOSAL_EXPIRED    ErrorCode = "PROPOSAL_EXPIRED"
+	ERR_INVALID_CHECKSUM    ErrorCode = "INVALID_CHECKSUM"
+	ERR_SUPPLY_MISMATCH     ErrorCode = "SUPPLY_MISMATCH"
+	ERR_ACCESS_DENIED       ErrorCode = "ACCESS_DENIED"
+	ERR_LEDGER              ErrorCode = "LEDGER_ERROR"
+)
+
+// ErrorPayload - structured error returned as the message of a failed response,
+// a replayed request carries the tx id which processed it
+type ErrorPayload struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	TxID    string    `json:"tx_id,omitempty"`
+}
+
+// errorResponse - build an error response carrying a structured error payload
+func errorResponse(code ErrorCode, message string) pb.Response {
+	payload := ErrorPayload{Code: code, Message: message}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return shim.Error(payload.Message)
+	}
+	return shim.Error(string(bytes))
+}
+
+// parseErrorPayload - structured error of a failed response, the message is kept as is if not structured
+func parseErrorPayload(resp pb.Response) ErrorPayload {
+	payload := ErrorPayload{}
+	if json.Unmarshal([]byte(resp.Message), &payload) != nil || payload.Code == "" {
+		return ErrorPayload{Message: resp.Message}
+	}
+	return payload
+}
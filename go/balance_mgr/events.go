@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// EventType - name of chaincode event, every state-changing invoke emits exactly one
+type EventType string
+
+const (
+	EVENT_ACCOUNT_CREATED     EventType = "AccountCreated"
+	EVENT_BALANCE_OPENED      EventType = "BalanceOpened"
+	EVENT_ASSET_REGISTERED    EventType = "AssetRegistered"
+	EVENT_CHARGED             EventType = "Charged"
+	EVENT_MINTED              EventType = "Minted"
+	EVENT_BURNED              EventType = "Burned"
+	EVENT_TRANSFERRED         EventType = "Transferred"
+	EVENT_BATCH_TRANSFERRED   EventType = "BatchTransferred"
+	EVENT_EXCHANGED           EventType = "Exchanged"
+	EVENT_HELD                EventType = "Held"
+	EVENT_HOLD_RELEASED       EventType = "HoldReleased"
+	EVENT_HOLD_CANCELLED      EventType = "HoldCancelled"
+	EVENT_FROZEN              EventType = "Frozen"
+	EVENT_UNFROZEN            EventType = "Unfrozen"
+	EVENT_CLOSED              EventType = "Closed"
+	EVENT_CREDIT_LIMIT_SET    EventType = "CreditLimitSet"
+	EVENT_OWNER_ASSIGNED      EventType = "OwnerAssigned"
+	EVENT_FEE_SCHEDULE_SET    EventType = "FeeScheduleSet"
+	EVENT_ENDORSEMENT_CHANGED EventType = "EndorsementChanged"
+	EVENT_ALLOWANCE_APPROVED  EventType = "AllowanceApproved"
+	EVENT_ALLOWANCE_REVOKED   EventType = "AllowanceRevoked"
+	EVENT_METADATA_UPDATED    EventType = "MetadataUpdated"
+	EVENT_SHIELDED            EventType = "Shielded"
+	EVENT_UNSHIELDED          EventType = "Unshielded"
+	EVENT_PRIVATE_TRANSFER    EventType = "PrivateTransferred"
+	EVENT_PRIVATE_CLAIMED     EventType = "PrivateClaimed"
+	EVENT_REQUEST_WINDOW_SET  EventType = "RequestWindowSet"
+	EVENT_REQUESTS_PURGED     EventType = "RequestsPurged"
+	EVENT_ORDER_SCHEDULED     EventType = "OrderScheduled"
+	EVENT_ORDER_CANCELLED     EventType = "OrderCancelled"
+	EVENT_ORDERS_EXECUTED     EventType = "OrdersExecuted"
+	EVENT_ALIAS_SET           EventType = "AliasSet"
+	EVENT_ALIAS_REMOVED       EventType = "AliasRemoved"
+	EVENT_ALIAS_TRANSFERRED   EventType = "AliasTransferred"
+	EVENT_INTEREST_RATE_SET   EventType = "InterestRateSet"
+	EVENT_INTEREST_ACCRUED    EventType = "InterestAccrued"
+	EVENT_SPENDING_LIMIT_SET  EventType = "SpendingLimitSet"
+	EVENT_ACCOUNT_CLASS_SET   EventType = "AccountClassSet"
+	EVENT_LIMIT_EXCEEDED      EventType = "LimitExceeded"
+	EVENT_SIGNER_POLICY_SET   EventType = "SignerPolicySet"
+	EVENT_TRANSFER_PROPOSED   EventType = "TransferProposed"
+	EVENT_TRANSFER_APPROVED   EventType = "TransferApproved"
+	EVENT_TRANSFER_REJECTED   EventType = "TransferRejected"
+	EVENT_ROLES_SET           EventType = "RolesSet"
+	EVENT_IMPORTED            EventType = "Imported"
+	EVENT_STATE_PUT           EventType = "StatePut"
+)
+
+const (
+	// EVENT_SCHEMA_VERSION - version of event envelope and payload layouts
+	EVENT_SCHEMA_VERSION = 1
+)
+
+// Event - envelope of every chaincode event, the payload layout is defined by type
+type Event struct {
+	Type          EventType   `json:"type"`
+	SchemaVersion int         `json:"schema_version"`
+	TxID          string      `json:"tx_id"`
+	Timestamp     string      `json:"timestamp"`
+	Actor         Identity    `json:"actor"`
+	Payload       interface{} `json:"payload"`
+}
+
+// AccountEvent - payload of AccountCreated and BalanceOpened
+type AccountEvent struct {
+	Account string   `json:"account"`
+	Asset   string   `json:"asset"`
+	Owner   Identity `json:"owner"`
+}
+
+// AssetEvent - payload of AssetRegistered
+type AssetEvent struct {
+	Asset     string  `json:"asset"`
+	Decimals  int     `json:"decimals"`
+	IssuerMSP string  `json:"issuer_msp"`
+	MaxSupply *Amount `json:"max_supply,omitempty"`
+}
+
+// AmountEvent - payload of Charged, Minted, Burned, Shielded and Unshielded, balance is the resulting public balance
+type AmountEvent struct {
+	Account string `json:"account"`
+	Asset   string `json:"asset"`
+	Amount  Amount `json:"amount"`
+	Balance Amount `json:"balance"`
+	Memo    string `json:"memo,omitempty"`
+}
+
+// TransferEvent - payload of Transferred, including the fee paid to the collector and the spender of a delegated transfer
+type TransferEvent struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Asset     string    `json:"asset"`
+	Amount    Amount    `json:"amount"`
+	Fee       Amount    `json:"fee"`
+	Collector string    `json:"collector,omitempty"`
+	Spender   *Identity `json:"spender,omitempty"`
+	Memo      string    `json:"memo,omitempty"`
+}
+
+// BatchTransferEvent - payload of BatchTransferred listing all legs
+type BatchTransferEvent struct {
+	Legs []*BatchLeg `json:"legs"`
+}
+
+// ExchangeEvent - payload of Exchanged
+type ExchangeEvent struct {
+	AccountA string `json:"account_a"`
+	AssetA   string `json:"asset_a"`
+	AmountA  Amount `json:"amount_a"`
+	AccountB string `json:"account_b"`
+	AssetB   string `json:"asset_b"`
+	AmountB  Amount `json:"amount_b"`
+	Memo     string `json:"memo,omitempty"`
+}
+
+// HoldEvent - payload of Held, HoldReleased and HoldCancelled
+type HoldEvent struct {
+	Hold *Hold  `json:"hold"`
+	Memo string `json:"memo,omitempty"`
+}
+
+// StatusEvent - payload of Frozen, Unfrozen and Closed, swept balances are listed for Closed
+type StatusEvent struct {
+	Account string            `json:"account"`
+	Status  AccountStatus     `json:"status"`
+	Reason  string            `json:"reason"`
+	Sweep   string            `json:"sweep,omitempty"`
+	Swept   map[string]Amount `json:"swept,omitempty"`
+}
+
+// CreditLimitEvent - payload of CreditLimitSet
+type CreditLimitEvent struct {
+	Account     string `json:"account"`
+	Asset       string `json:"asset"`
+	CreditLimit Amount `json:"credit_limit"`
+}
+
+// OwnerEvent - payload of OwnerAssigned
+type OwnerEvent struct {
+	Account string   `json:"account"`
+	Owner   Identity `json:"owner"`
+}
+
+// FeeScheduleEvent - payload of FeeScheduleSet, an empty schedule means removed
+type FeeScheduleEvent struct {
+	Asset    string       `json:"asset"`
+	Schedule *FeeSchedule `json:"schedule,omitempty"`
+}
+
+// AllowanceEvent - payload of AllowanceApproved and AllowanceRevoked
+type AllowanceEvent struct {
+	Owner   Identity `json:"owner"`
+	Spender Identity `json:"spender"`
+	Asset   string   `json:"asset"`
+	Amount  *Amount  `json:"amount,omitempty"`
+	Expiry  string   `json:"expiry,omitempty"`
+}
+
+// MetadataEvent - payload of MetadataUpdated, naming the encrypted fields but never their values
+type MetadataEvent struct {
+	Account    string   `json:"account"`
+	Fields     []string `json:"fields"`
+	KeyVersion string   `json:"key_version"`
+}
+
+// PrivateTransferEvent - payload of PrivateTransferred, the amount is only known to the collections
+type PrivateTransferEvent struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Asset      string `json:"asset"`
+	Credit     string `json:"credit"`
+	Collection string `json:"collection"`
+}
+
+// PrivateClaimEvent - payload of PrivateClaimed listing the credits folded into the balance
+type PrivateClaimEvent struct {
+	Account string   `json:"account"`
+	Asset   string   `json:"asset"`
+	Credits []string `json:"credits"`
+}
+
+// RequestWindowEvent - payload of RequestWindowSet
+type RequestWindowEvent struct {
+	Window string `json:"window"`
+}
+
+// RequestsPurgedEvent - payload of RequestsPurged
+type RequestsPurgedEvent struct {
+	Purged int `json:"purged"`
+}
+
+// OrderEvent - payload of OrderScheduled and OrderCancelled
+type OrderEvent struct {
+	Order  *StandingOrder `json:"order"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+// OrderExecutionEvent - payload of OrdersExecuted listing the outcome of every processed order
+type OrderExecutionEvent struct {
+	Executions []*OrderExecution `json:"executions"`
+}
+
+// InterestRateEvent - payload of InterestRateSet, an empty rate means removed
+type InterestRateEvent struct {
+	Asset   string        `json:"asset"`
+	Account string        `json:"account,omitempty"`
+	Rate    *InterestRate `json:"rate,omitempty"`
+}
+
+// InterestAccruedEvent - payload of InterestAccrued listing the outcome of every accrued balance
+type InterestAccruedEvent struct {
+	Accruals []*Accrual `json:"accruals"`
+}
+
+// SpendingLimitEvent - payload of SpendingLimitSet, an empty limit means removed
+type SpendingLimitEvent struct {
+	Asset string         `json:"asset"`
+	Scope LimitScope     `json:"scope"`
+	Name  string         `json:"name"`
+	Limit *SpendingLimit `json:"limit,omitempty"`
+}
+
+// AccountClassEvent - payload of AccountClassSet, an empty class means removed
+type AccountClassEvent struct {
+	Account string `json:"account"`
+	Class   string `json:"class"`
+}
+
+// SignerPolicyEvent - payload of SignerPolicySet, an empty policy means removed
+type SignerPolicyEvent struct {
+	Account string        `json:"account"`
+	Asset   string        `json:"asset"`
+	Policy  *SignerPolicy `json:"policy,omitempty"`
+}
+
+// ProposalEvent - payload of TransferProposed, TransferApproved and TransferRejected, carrying the
+// transfer once the proposal executed
+type ProposalEvent struct {
+	Proposal *TransferProposal `json:"proposal"`
+	Signer   Identity          `json:"signer"`
+	Transfer *TransferEvent    `json:"transfer,omitempty"`
+}
+
+// RolesEvent - payload of RolesSet
+type RolesEvent struct {
+	AdminMSPs  []string `json:"admin_msps"`
+	MinterMSPs []string `json:"minter_msps"`
+}
+
+// ImportEvent - payload of Imported, counting the documents loaded by one page
+type ImportEvent struct {
+	Assets   int  `json:"assets"`
+	Supplies int  `json:"supplies"`
+	Accounts int  `json:"accounts"`
+	Final    bool `json:"final"`
+}
+
+// StateEvent - payload of StatePut
+type StateEvent struct {
+	Key string `json:"key"`
+}
+
+// emitEvent - set the event of current transaction. Fabric keeps only the last event
+// of a transaction, so an invoke must emit once.
+func emitEvent(stub shim.ChaincodeStubInterface, eventType EventType, payload interface{}) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	actor, err := creatorIdentity(stub)
+	if err != nil {
+		return err
+	}
+	event := Event{
+		Type:          eventType,
+		SchemaVersion: EVENT_SCHEMA_VERSION,
+		TxID:          stub.GetTxID(),
+		Timestamp:     timestamp,
+		Actor:         actor,
+		Payload:       payload,
+	}
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(string(eventType), bytes)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_FEE = "fee"
+)
+
+type FeeType string
+
+const (
+	FEE_FLAT    FeeType = "FLAT"
+	FEE_PERCENT FeeType = "PERCENT"
+	FEE_TIERED  FeeType = "TIERED"
+)
+
+// FeeTier - fee of transfers from amount on, until the next tier starts
+type FeeTier struct {
+	From Amount  `json:"from"`
+	Flat *Amount `json:"flat,omitempty"`
+	Rate *Amount `json:"rate,omitempty"`
+}
+
+// FeeSchedule - transfer fee of an asset paid by the sender to the collector account,
+// stored under composite key of 'fee'. Rates are percentages, Min and Max bound the fee of every type.
+type FeeSchedule struct {
+	AbstractDoc
+	Asset     string     `json:"asset"`
+	Collector string     `json:"collector"`
+	Type      FeeType    `json:"type"`
+	Flat      *Amount    `json:"flat,omitempty"`
+	Rate      *Amount    `json:"rate,omitempty"`
+	Min       *Amount    `json:"min,omitempty"`
+	Max       *Amount    `json:"max,omitempty"`
+	Tiers     []*FeeTier `json:"tiers,omitempty"`
+	UpdatedTx string     `json:"updated_tx"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+// ParseFeeSchedule - parse fee schedule document
+func ParseFeeSchedule(data []byte) (*FeeSchedule, error) {
+	schedule := FeeSchedule{}
+	err := json.Unmarshal(data, &schedule)
+	if err != nil || schedule.DocType != DOC_FEE {
+		return nil, fmt.Errorf(`invalid fee schedule document. (value: "%s")`, string(data))
+	}
+	if schedule.Version > FEE_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported fee schedule document version. (expecting <= %d, actual: %d)`, FEE_DOC_VERSION, schedule.Version)
+	}
+	return &schedule, nil
+}
+
+// Normalize - validate schedule and rescale its amounts to the decimals of asset
+func (t *FeeSchedule) Normalize(decimals int) error {
+	fields := []**Amount{&t.Flat, &t.Min, &t.Max}
+	for idx, tier := range t.Tiers {
+		if tier == nil || (tier.Flat == nil && tier.Rate == nil) {
+			return fmt.Errorf("empty tier, flat fee or rate required. (tier: %d)", idx)
+		}
+		from := &tier.From
+		fields = append(fields, &tier.Flat, &from)
+	}
+	for _, field := range fields {
+		if *field == nil {
+			continue
+		}
+		amount, err := (*field).Rescale(decimals)
+		if err != nil {
+			return err
+		}
+		if amount.Sign() < 0 {
+			return fmt.Errorf("negative fee or tier start. (actual: %s)", amount)
+		}
+		**field = amount
+	}
+
+	rates := []*Amount{t.Rate}
+	for _, tier := range t.Tiers {
+		rates = append(rates, tier.Rate)
+	}
+	for _, rate := range rates {
+		if rate != nil && (rate.Sign() < 0 || rate.Cmp(AmountFromInt(100)) > 0) {
+			return fmt.Errorf("invalid rate, expecting a percentage between 0 and 100. (actual: %s)", rate)
+		}
+	}
+	if t.Min != nil && t.Max != nil && t.Min.Cmp(*t.Max) > 0 {
+		return fmt.Errorf("min fee exceeds max fee. (min: %s, max: %s)", t.Min, t.Max)
+	}
+
+	switch t.Type {
+	case FEE_FLAT:
+		if t.Flat == nil {
+			return fmt.Errorf("flat fee required")
+		}
+	case FEE_PERCENT:
+		if t.Rate == nil {
+			return fmt.Errorf("rate required")
+		}
+	case FEE_TIERED:
+		if len(t.Tiers) == 0 {
+			return fmt.Errorf("tiers required")
+		}
+		for idx, tier := range t.Tiers {
+			if idx > 0 && tier.From.Cmp(t.Tiers[idx-1].From) <= 0 {
+				return fmt.Errorf("tiers must be in ascending order of from amount. (tier: %d)", idx)
+			}
+		}
+	default:
+		return fmt.Errorf(`unknown fee type, expecting "%s", "%s" or "%s". (actual: "%s")`, FEE_FLAT, FEE_PERCENT, FEE_TIERED, t.Type)
+	}
+	return nil
+}
+
+// Compute - fee of transferring amount, with the scale of amount
+func (t *FeeSchedule) Compute(amount Amount) Amount {
+	scale := amount.Scale()
+	fee := ZeroAmount(scale)
+	switch t.Type {
+	case FEE_FLAT:
+		fee = fee.Add(*t.Flat)
+	case FEE_PERCENT:
+		fee = amount.Percent(*t.Rate, scale)
+	case FEE_TIERED:
+		// amounts below the first tier are free
+		var tier *FeeTier
+		for _, candidate := range t.Tiers {
+			if candidate.From.Cmp(amount) > 0 {
+				break
+			}
+			tier = candidate
+		}
+		if tier != nil {
+			if tier.Flat != nil {
+				fee = fee.Add(*tier.Flat)
+			}
+			if tier.Rate != nil {
+				fee = fee.Add(amount.Percent(*tier.Rate, scale))
+			}
+		}
+	}
+	if t.Min != nil && fee.Cmp(*t.Min) < 0 {
+		fee = ZeroAmount(scale).Add(*t.Min)
+	}
+	if t.Max != nil && fee.Cmp(*t.Max) > 0 {
+		fee = ZeroAmount(scale).Add(*t.Max)
+	}
+	return fee
+}
+
+// getFeeSchedule - load fee schedule of asset, nil if transfers of asset are free
+func getFeeSchedule(stub shim.ChaincodeStubInterface, assetCode string) (*FeeSchedule, error) {
+	key, err := stub.CreateCompositeKey(INDEX_FEE, []string{assetCode})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseFeeSchedule(valBytes)
+}
+
+// setFeeSchedule: set fee schedule of asset from a JSON document, an empty document removes it (admin only)
+func (t *BalanceManager) setFeeSchedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set fee schedule.")
+	}
+
+	asset, resp := loadAsset(stub, args[0])
+	if asset == nil {
+		return resp
+	}
+	key, err := stub.CreateCompositeKey(INDEX_FEE, []string{asset.Code})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if args[1] == "" {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = emitEvent(stub, EVENT_FEE_SCHEDULE_SET, FeeScheduleEvent{Asset: asset.Code})
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	schedule := FeeSchedule{}
+	err = json.Unmarshal([]byte(args[1]), &schedule)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid fee schedule, expecting a JSON document. cause: (%s)", err))
+	}
+	err = schedule.Normalize(asset.Decimals)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid fee schedule. cause: (%s)", err))
+	}
+
+	// collector must be able to receive the asset
+	_, _, resp = loadBalance(stub, schedule.Collector, asset)
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	schedule.DocType = DOC_FEE
+	schedule.Version = FEE_DOC_VERSION
+	schedule.Asset = asset.Code
+	schedule.UpdatedTx = stub.GetTxID()
+	schedule.UpdatedAt = timestamp
+
+	bytes, err := json.Marshal(schedule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_FEE_SCHEDULE_SET, FeeScheduleEvent{Asset: asset.Code, Schedule: &schedule})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryFeeSchedule: query fee schedule of asset
+func (t *BalanceManager) queryFeeSchedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	schedule, err := getFeeSchedule(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if schedule == nil {
+		return errorResponse(ERR_FEE_NOT_FOUND, fmt.Sprintf(`Fee schedule not found. (Asset: "%s")`, args[0]))
+	}
+
+	bytes, err := json.Marshal(schedule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
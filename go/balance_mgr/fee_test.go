@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseSchedule(t *testing.T, data string, decimals int) *FeeSchedule {
+	schedule := FeeSchedule{}
+	assert.Nil(t, json.Unmarshal([]byte(data), &schedule))
+	assert.Nil(t, schedule.Normalize(decimals))
+	return &schedule
+}
+
+func Test_FeeFlat(t *testing.T) {
+	schedule := parseSchedule(t, `{"collector":"fees","type":"FLAT","flat":"0.5"}`, 2)
+	amount, _ := ParseAmount("100", 2)
+	assert.Equal(t, "0.50", schedule.Compute(amount).String())
+}
+
+func Test_FeePercent(t *testing.T) {
+	schedule := parseSchedule(t, `{"collector":"fees","type":"PERCENT","rate":"1.5","min":"1","max":"10"}`, 2)
+	amount, _ := ParseAmount("200", 2)
+	assert.Equal(t, "3.00", schedule.Compute(amount).String())
+
+	amount, _ = ParseAmount("10", 2)
+	assert.Equal(t, "1.00", schedule.Compute(amount).String(), "raised to min.")
+
+	amount, _ = ParseAmount("5000", 2)
+	assert.Equal(t, "10.00", schedule.Compute(amount).String(), "capped at max.")
+}
+
+func Test_FeeTiered(t *testing.T) {
+	schedule := parseSchedule(t, `{"collector":"fees","type":"TIERED","tiers":[{"from":"10","flat":"1"},{"from":"1000","rate":"0.1"}]}`, 2)
+	assert.Equal(t, "10.00", schedule.Tiers[0].From.String(), "rescaled to the asset.")
+	amount, _ := ParseAmount("5", 2)
+	assert.Equal(t, "0.00", schedule.Compute(amount).String(), "below first tier.")
+
+	amount, _ = ParseAmount("999.99", 2)
+	assert.Equal(t, "1.00", schedule.Compute(amount).String())
+
+	amount, _ = ParseAmount("2000", 2)
+	assert.Equal(t, "2.00", schedule.Compute(amount).String())
+}
+
+func Test_FeeInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"type":"FLAT"}`,
+		`{"type":"FLAT","flat":"0.001"}`,
+		`{"type":"PERCENT","rate":"101"}`,
+		`{"type":"PERCENT","rate":"1","min":"5","max":"1"}`,
+		`{"type":"TIERED","tiers":[{"from":"10","flat":"1"},{"from":"5","flat":"1"}]}`,
+		`{"type":"TIERED","tiers":[{"from":"10"}]}`,
+		`{"type":"TIERED","tiers":[{"from":"-1","flat":"1"}]}`,
+		`{"type":"TIERED","tiers":[{"from":"0.001","flat":"1"}]}`,
+		`{"type":"UNKNOWN"}`,
+	} {
+		schedule := FeeSchedule{}
+		assert.Nil(t, json.Unmarshal([]byte(data), &schedule))
+		assert.NotNil(t, schedule.Normalize(2), data)
+	}
+}
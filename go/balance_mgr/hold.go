@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_HOLD = "hold"
+)
+
+type HoldStatus string
+
+const (
+	HOLD_HELD      HoldStatus = "HELD"
+	HOLD_RELEASED  HoldStatus = "RELEASED"
+	HOLD_CANCELLED HoldStatus = "CANCELLED"
+)
+
+// Hold - amount locked in escrow from an account for a beneficiary, identified by the tx id which created it
+type Hold struct {
+	AbstractDoc
+	ID          string     `json:"id"`
+	Account     string     `json:"account"`
+	Asset       string     `json:"asset"`
+	Amount      Amount     `json:"amount"`
+	Beneficiary string     `json:"beneficiary"`
+	Approver    *Identity  `json:"approver,omitempty"`
+	Expiry      string     `json:"expiry"`
+	Status      HoldStatus `json:"status"`
+	CreatedAt   string     `json:"created_at"`
+	ClosedTx    string     `json:"closed_tx,omitempty"`
+	ClosedAt    string     `json:"closed_at,omitempty"`
+}
+
+// ParseHold - parse hold document
+func ParseHold(data []byte) (*Hold, error) {
+	hold := Hold{}
+	err := json.Unmarshal(data, &hold)
+	if err != nil || hold.DocType != DOC_HOLD {
+		return nil, fmt.Errorf(`invalid hold document. (value: "%s")`, string(data))
+	}
+	if hold.Version > HOLD_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported hold document version. (expecting <= %d, actual: %d)`, HOLD_DOC_VERSION, hold.Version)
+	}
+	return &hold, nil
+}
+
+// getHold - load hold document, nil if not existing
+func getHold(stub shim.ChaincodeStubInterface, holdID string) (*Hold, error) {
+	key, err := stub.CreateCompositeKey(INDEX_HOLD, []string{holdID})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseHold(valBytes)
+}
+
+// putHold - write hold document to ledger
+func putHold(stub shim.ChaincodeStubInterface, hold *Hold) error {
+	key, err := stub.CreateCompositeKey(INDEX_HOLD, []string{hold.ID})
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// hold: lock amount of account (owner only) for beneficiary until expiry, optionally released by an approver.
+// The tx id is returned as id of the hold.
+func (t *BalanceManager) hold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("hold amount of account")
+
+	if len(args) != 5 && len(args) != 7 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 5 or 7")
+	}
+
+	accountName := args[0]
+	beneficiaryName := args[3]
+	if accountName == beneficiaryName {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Hold for the same account is not allowed. (Account: "%s")`, accountName))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, args[4])
+	if err != nil || !expiry.After(now) {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid expiry, expecting a future time in RFC3339 format. (actual: "%s")`, args[4]))
+	}
+
+	var approver *Identity
+	if len(args) == 7 {
+		approver = &Identity{ID: args[5], MSPID: args[6]}
+		if approver.ID == "" || approver.MSPID == "" {
+			return errorResponse(ERR_INVALID_ARGUMENT, "Approver id and MSP id must not be empty.")
+		}
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, balance, resp := loadBalance(stub, accountName, asset)
+	if account == nil {
+		return resp
+	}
+	err = authorizeOwner(stub, account)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, err.Error())
+	}
+	beneficiary, _, resp := loadBalance(stub, beneficiaryName, asset)
+	if beneficiary == nil {
+		return resp
+	}
+	if resp, inactive := notActive(account); inactive {
+		return resp
+	}
+	if resp, inactive := notActive(beneficiary); inactive {
+		return resp
+	}
+
+	amount, err := parseAmount(args[2], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	if !balance.CanDebit(amount) {
+		return insufficientFunds(accountName, asset.Code, balance, amount)
+	}
+	resp = requireApproval(stub, accountName, asset, amount)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	// the held amount is committed to the beneficiary, so it counts as outgoing when held
+	spending := newSpendingTracker(stub)
+	resp = spending.spend(account, asset, amount)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	err = spending.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	timestamp := now.Format(TIMESTAMP_FORMAT)
+	hold := Hold{
+		ID:          stub.GetTxID(),
+		Account:     accountName,
+		Asset:       asset.Code,
+		Amount:      amount,
+		Beneficiary: beneficiaryName,
+		Approver:    approver,
+		Expiry:      expiry.UTC().Format(TIMESTAMP_FORMAT),
+		Status:      HOLD_HELD,
+		CreatedAt:   timestamp,
+	}
+	hold.DocType = DOC_HOLD
+	hold.Version = HOLD_DOC_VERSION
+	err = putHold(stub, &hold)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	balance.Held = balance.Held.Add(amount)
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.AppendHold(ENTRY_HOLD, accountName, asset.Code, beneficiaryName, ZeroAmount(asset.Decimals), balance, hold.ID, "")
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_HELD, HoldEvent{Hold: &hold})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success([]byte(hold.ID))
+}
+
+// release: pay held amount to beneficiary before expiry, by the approver if one is set,
+// otherwise by the owner of the held account
+func (t *BalanceManager) release(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("release hold")
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	hold, resp := loadOpenHold(stub, args[0])
+	if hold == nil {
+		return resp
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if hold.expired(now) {
+		return errorResponse(ERR_HOLD_EXPIRED, fmt.Sprintf(`Hold expired, only cancellation is allowed. (Hold: "%s", expiry: "%s")`, hold.ID, hold.Expiry))
+	}
+
+	cache := newAccountCache(stub)
+	asset, err := cache.getAsset(hold.Asset)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	account, err := cache.getAccount(hold.Account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	beneficiary, err := cache.getAccount(hold.Beneficiary)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if asset == nil || account == nil || beneficiary == nil {
+		return errorResponse(ERR_LEDGER, fmt.Sprintf(`Asset or accounts of hold not found. (Hold: "%s")`, hold.ID))
+	}
+
+	if hold.Approver != nil {
+		creator, err := creatorIdentity(stub)
+		if err != nil || !hold.Approver.Equals(creator) {
+			return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`Only the approver is allowed to release hold. (Hold: "%s")`, hold.ID))
+		}
+	} else {
+		err = authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+	if resp, inactive := notActive(account); inactive {
+		return resp
+	}
+	if resp, inactive := notActive(beneficiary); inactive {
+		return resp
+	}
+
+	balance, resp := accountBalance(account, asset)
+	if balance == nil {
+		return resp
+	}
+	balanceTo, resp := accountBalance(beneficiary, asset)
+	if balanceTo == nil {
+		return resp
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, asset, account, beneficiary)
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	balance.Held = balance.Held.Sub(hold.Amount)
+	balance.Amount = balance.Amount.Sub(hold.Amount)
+	balanceTo.Amount = balanceTo.Amount.Add(hold.Amount)
+
+	memo := optionalArg(args, 1)
+	err = journal.AppendHold(ENTRY_HOLD_RELEASE, hold.Account, hold.Asset, hold.Beneficiary, hold.Amount.Neg(), balance, hold.ID, memo)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.Append(ENTRY_TRANSFER_IN, hold.Beneficiary, hold.Asset, hold.Account, hold.Amount, balanceTo.Amount, memo)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return closeHold(stub, cache, hold, HOLD_RELEASED, now, EVENT_HOLD_RELEASED, memo)
+}
+
+// cancelHold: unlock held amount, by the owner of the beneficiary account or an admin at any time,
+// or by the owner of the held account once expired
+func (t *BalanceManager) cancelHold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("cancel hold")
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	hold, resp := loadOpenHold(stub, args[0])
+	if hold == nil {
+		return resp
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	cache := newAccountCache(stub)
+	asset, err := cache.getAsset(hold.Asset)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	account, err := cache.getAccount(hold.Account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if asset == nil || account == nil {
+		return errorResponse(ERR_LEDGER, fmt.Sprintf(`Asset or account of hold not found. (Hold: "%s")`, hold.ID))
+	}
+
+	if !isAdmin(stub) {
+		beneficiary, err := getAccount(stub, hold.Beneficiary)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if beneficiary == nil || authorizeOwner(stub, beneficiary) != nil {
+			if !hold.expired(now) {
+				return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`Only the beneficiary or admin is allowed to cancel hold before expiry. (Hold: "%s", expiry: "%s")`, hold.ID, hold.Expiry))
+			}
+			err = authorizeOwner(stub, account)
+			if err != nil {
+				return errorResponse(ERR_ACCESS_DENIED, err.Error())
+			}
+		}
+	}
+
+	balance, resp := accountBalance(account, asset)
+	if balance == nil {
+		return resp
+	}
+	balance.Held = balance.Held.Sub(hold.Amount)
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	reason := optionalArg(args, 1)
+	err = journal.AppendHold(ENTRY_HOLD_CANCEL, hold.Account, hold.Asset, hold.Beneficiary, ZeroAmount(asset.Decimals), balance, hold.ID, reason)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return closeHold(stub, cache, hold, HOLD_CANCELLED, now, EVENT_HOLD_CANCELLED, reason)
+}
+
+// queryHold: query hold by id
+func (t *BalanceManager) queryHold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	hold, err := getHold(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if hold == nil {
+		return errorResponse(ERR_HOLD_NOT_FOUND, fmt.Sprintf(`Hold not found. (Hold: "%s")`, args[0]))
+	}
+
+	bytes, err := json.Marshal(hold)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// loadOpenHold - load hold which is neither released nor cancelled, the error response is returned otherwise
+func loadOpenHold(stub shim.ChaincodeStubInterface, holdID string) (*Hold, pb.Response) {
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if hold == nil {
+		return nil, errorResponse(ERR_HOLD_NOT_FOUND, fmt.Sprintf(`Hold not found. (Hold: "%s")`, holdID))
+	}
+	if hold.Status != HOLD_HELD {
+		return nil, errorResponse(ERR_INVALID_STATUS, fmt.Sprintf(`Hold already closed. (Hold: "%s", status: "%s")`, holdID, hold.Status))
+	}
+	return hold, shim.Success(nil)
+}
+
+// closeHold - write accounts touched by hold, mark hold with final status and emit its event
+func closeHold(stub shim.ChaincodeStubInterface, cache *accountCache, hold *Hold, status HoldStatus, now time.Time, eventType EventType, memo string) pb.Response {
+	err := cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	hold.Status = status
+	hold.ClosedTx = stub.GetTxID()
+	hold.ClosedAt = now.Format(TIMESTAMP_FORMAT)
+	err = putHold(stub, hold)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, eventType, HoldEvent{Hold: hold, Memo: memo})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// expired - check whether hold is expired at time
+func (t *Hold) expired(now time.Time) bool {
+	return t.Expiry <= now.UTC().Format(TIMESTAMP_FORMAT)
+}
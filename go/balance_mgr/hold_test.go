@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseHold(t *testing.T) {
+	data := `{"doc_type":"HOLD","version":1,"id":"tx1","account":"a","asset":"PTS","amount":"5","beneficiary":"b","expiry":"2020-01-02T00:00:00.000000000Z","status":"HELD"}`
+	hold, err := ParseHold([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", hold.Amount.String())
+	assert.Nil(t, hold.Approver)
+
+	assert.False(t, hold.expired(time.Date(2020, 1, 1, 23, 59, 59, 0, time.UTC)))
+	assert.True(t, hold.expired(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+
+	_, err = ParseHold([]byte(`{"doc_type":"ACCOUNT","version":1}`))
+	assert.NotNil(t, err)
+}
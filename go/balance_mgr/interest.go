@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_INTEREST = "interest"
+
+	// SECONDS_PER_YEAR - annual rates accrue per second over a year of 365 days
+	SECONDS_PER_YEAR = 365 * 24 * 60 * 60
+	// INTEREST_CARRY_DIGITS - fractional digits beyond the decimals of the asset kept of unpaid interest
+	INTEREST_CARRY_DIGITS = 9
+	// MAX_RATE_HISTORY - number of earlier rates kept, balances not accrued for longer earn the oldest
+	MAX_RATE_HISTORY = 64
+)
+
+// InterestRate - annual interest rate in percent of an asset, or of one account overriding the rate of
+// the asset, stored under composite key of 'interest'. Interest is paid out of the pool account named
+// by the rate of the asset, which the issuer funds by mint, so that accrual leaves the supply unchanged.
+// History keeps the earlier rates, so that balances accrue each period at the rate then in force.
+type InterestRate struct {
+	AbstractDoc
+	Asset     string        `json:"asset"`
+	Account   string        `json:"account,omitempty"`
+	Rate      Amount        `json:"rate"`
+	Pool      string        `json:"pool,omitempty"`
+	Since     string        `json:"since,omitempty"`
+	History   []*RatePeriod `json:"history,omitempty"`
+	UpdatedTx string        `json:"updated_tx"`
+	UpdatedAt string        `json:"updated_at"`
+}
+
+// RatePeriod - rate in force from since until the next rate was set, oldest first
+type RatePeriod struct {
+	Rate  Amount `json:"rate"`
+	Since string `json:"since,omitempty"`
+	Until string `json:"until"`
+}
+
+// Accrual - interest posted to a balance, or the reason it stays pending
+type Accrual struct {
+	Account  string `json:"account"`
+	Asset    string `json:"asset"`
+	Interest Amount `json:"interest"`
+	Balance  Amount `json:"balance"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AccrualReport - result of accrueAll, next is the account to continue after, empty when done
+type AccrualReport struct {
+	Accruals []*Accrual `json:"accruals"`
+	Next     string     `json:"next"`
+}
+
+// ParseInterestRate - parse interest rate document
+func ParseInterestRate(data []byte) (*InterestRate, error) {
+	rate := InterestRate{}
+	err := json.Unmarshal(data, &rate)
+	if err != nil || rate.DocType != DOC_INTEREST {
+		return nil, fmt.Errorf(`invalid interest rate document. (value: "%s")`, string(data))
+	}
+	if rate.Version > INTEREST_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported interest rate document version. (expecting <= %d, actual: %d)`, INTEREST_DOC_VERSION, rate.Version)
+	}
+	return &rate, nil
+}
+
+// computeInterest - simple interest of principal at annual rate percent over seconds, with the scale of
+// principal. Rounded down, so that the pool never pays more than earned.
+func computeInterest(principal Amount, rate Amount, seconds int64) Amount {
+	if principal.Sign() <= 0 || rate.Sign() <= 0 || seconds <= 0 {
+		return ZeroAmount(principal.Scale())
+	}
+	units := new(big.Int).Mul(principal.value(), rate.value())
+	units.Mul(units, big.NewInt(seconds))
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(rate.Scale())), nil)
+	divisor.Mul(divisor, big.NewInt(100*SECONDS_PER_YEAR))
+	return Amount{units: units.Quo(units, divisor), scale: principal.Scale()}
+}
+
+// interestOver - interest of principal between from and to, each period at the rate then in force. The
+// oldest period extends back without bound, rates written before history was kept have no start.
+func interestOver(principal Amount, rate *InterestRate, from time.Time, to time.Time) Amount {
+	periods := append(append([]*RatePeriod{}, rate.History...), &RatePeriod{Rate: rate.Rate, Since: rate.Since})
+	interest := ZeroAmount(principal.Scale())
+	for idx, period := range periods {
+		start, end := from, to
+		if since, err := time.Parse(TIMESTAMP_FORMAT, period.Since); err == nil && idx > 0 && since.After(start) {
+			start = since
+		}
+		if until, err := time.Parse(TIMESTAMP_FORMAT, period.Until); err == nil && until.Before(end) {
+			end = until
+		}
+		if end.After(start) {
+			interest = interest.Add(computeInterest(principal, period.Rate, int64(end.Sub(start)/time.Second)))
+		}
+	}
+	return interest
+}
+
+// owedInterest - interest earned by balance and not paid yet, with INTEREST_CARRY_DIGITS fractional
+// digits beyond decimals: the amount carried from earlier accruals plus the interest since the last one
+func owedInterest(balance *Balance, rate *InterestRate, now time.Time, decimals int) Amount {
+	owed := ZeroAmount(decimals + INTEREST_CARRY_DIGITS)
+	if balance.AccruedInterest != nil {
+		owed = owed.Add(*balance.AccruedInterest)
+	}
+	accruedAt, err := time.Parse(TIMESTAMP_FORMAT, balance.AccruedAt)
+	if err != nil {
+		return owed
+	}
+	principal, err := balance.Amount.Rescale(decimals + INTEREST_CARRY_DIGITS)
+	if err != nil {
+		return owed
+	}
+	return owed.Add(interestOver(principal, rate, accruedAt, now))
+}
+
+// splitInterest - owed interest rounded down to decimals, and the fraction left over
+func splitInterest(owed Amount, decimals int) (Amount, Amount) {
+	if owed.Scale() <= decimals {
+		whole, _ := owed.Rescale(decimals)
+		return whole, ZeroAmount(decimals)
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(owed.Scale()-decimals)), nil)
+	whole := Amount{units: new(big.Int).Quo(owed.value(), factor), scale: decimals}
+	return whole, owed.Sub(whole)
+}
+
+// pendingInterest - interest balance would be paid if accrued now, zero until accrual started
+func pendingInterest(balance *Balance, rate *InterestRate, now time.Time, decimals int) Amount {
+	interest, _ := splitInterest(owedInterest(balance, rate, now, decimals), decimals)
+	return interest
+}
+
+// carryInterest - keep interest owed to balance for the next accrual
+func carryInterest(balance *Balance, owed Amount) {
+	if owed.Sign() == 0 {
+		balance.AccruedInterest = nil
+		return
+	}
+	balance.AccruedInterest = &owed
+}
+
+func interestKey(stub shim.ChaincodeStubInterface, assetCode string, accountName string) (string, error) {
+	if accountName == "" {
+		return stub.CreateCompositeKey(INDEX_INTEREST, []string{assetCode})
+	}
+	return stub.CreateCompositeKey(INDEX_INTEREST, []string{assetCode, accountName})
+}
+
+// getInterestRate - load rate of asset, or the override of account if named, nil if not set
+func getInterestRate(stub shim.ChaincodeStubInterface, assetCode string, accountName string) (*InterestRate, error) {
+	key, err := interestKey(stub, assetCode, accountName)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseInterestRate(valBytes)
+}
+
+// interestTerms - rate applying to balance of account and the pool paying it, nil if the balance earns
+// no interest. The pool itself earns none.
+func interestTerms(stub shim.ChaincodeStubInterface, assetCode string, accountName string) (*InterestRate, string, error) {
+	assetRate, err := getInterestRate(stub, assetCode, "")
+	if err != nil || assetRate == nil || assetRate.Pool == accountName {
+		return nil, "", err
+	}
+	rate, err := getInterestRate(stub, assetCode, accountName)
+	if err != nil {
+		return nil, "", err
+	}
+	if rate == nil {
+		rate = assetRate
+	}
+	return rate, assetRate.Pool, nil
+}
+
+// accrueInterest - post interest of balance earned since it was last accrued, paid out of the pool
+// account loaded through cache. Accrual of a balance starts on its first touch after a rate is set and
+// every later touch stamps it, so that a changed principal only earns from then on. Interest below one
+// unit, or which the pool can not pay, is carried in AccruedInterest; when the pool is inactive or can
+// not pay, the error response is returned.
+func accrueInterest(stub shim.ChaincodeStubInterface, cache *accountCache, journal *Journal, account *Account, asset *Asset, now time.Time) (Amount, pb.Response) {
+	zero := ZeroAmount(asset.Decimals)
+	balance := account.GetBalance(asset.Code)
+	if balance == nil || account.Status == STATUS_CLOSED {
+		return zero, shim.Success(nil)
+	}
+	rate, poolName, err := interestTerms(stub, asset.Code, account.Name)
+	if err != nil {
+		return zero, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if rate == nil {
+		// accrual restarts from the next touch once a rate is set again
+		balance.AccruedAt = ""
+		return zero, shim.Success(nil)
+	}
+	if balance.AccruedAt == "" {
+		balance.AccruedAt = now.Format(TIMESTAMP_FORMAT)
+		return zero, shim.Success(nil)
+	}
+
+	owed := owedInterest(balance, rate, now, asset.Decimals)
+	balance.AccruedAt = now.Format(TIMESTAMP_FORMAT)
+	carryInterest(balance, owed)
+	interest, fraction := splitInterest(owed, asset.Decimals)
+	if interest.Sign() == 0 {
+		return zero, shim.Success(nil)
+	}
+	pool, balancePool, resp := cachedBalance(cache, poolName, asset)
+	if pool == nil {
+		return zero, resp
+	}
+	if resp, inactive := notActive(pool); inactive {
+		return zero, resp
+	}
+	if !balancePool.CanDebit(interest) {
+		return zero, insufficientFunds(poolName, asset.Code, balancePool, interest)
+	}
+
+	balancePool.Amount = balancePool.Amount.Sub(interest)
+	err = journal.Append(ENTRY_INTEREST_PAID, poolName, asset.Code, account.Name, interest.Neg(), balancePool.Amount, "")
+	if err != nil {
+		return zero, errorResponse(ERR_LEDGER, err.Error())
+	}
+	balance.Amount = balance.Amount.Add(interest)
+	carryInterest(balance, fraction)
+	err = journal.Append(ENTRY_INTEREST, account.Name, asset.Code, poolName, interest, balance.Amount, "")
+	if err != nil {
+		return zero, errorResponse(ERR_LEDGER, err.Error())
+	}
+	return interest, shim.Success(nil)
+}
+
+// accrueTouched - accrue interest of balances touched by an invoke, failures other than of the ledger
+// leave interest pending without refusing the invoke. Every invoke changing the amount of a balance
+// accrues it first, so that the new amount earns from then on; nil accounts are skipped.
+func accrueTouched(stub shim.ChaincodeStubInterface, cache *accountCache, journal *Journal, asset *Asset, accounts ...*Account) pb.Response {
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	for _, account := range accounts {
+		if account == nil {
+			continue
+		}
+		_, resp := accrueInterest(stub, cache, journal, account, asset, now)
+		if resp.Status != shim.OK && parseErrorPayload(resp).Code == ERR_LEDGER {
+			return resp
+		}
+	}
+	return shim.Success(nil)
+}
+
+// putInterestRate - write rate, an empty rate removes it
+func putInterestRate(stub shim.ChaincodeStubInterface, rate *InterestRate, remove bool) pb.Response {
+	key, err := interestKey(stub, rate.Asset, rate.Account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if remove {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = emitEvent(stub, EVENT_INTEREST_RATE_SET, InterestRateEvent{Asset: rate.Asset, Account: rate.Account})
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		return shim.Success(nil)
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	rate.DocType = DOC_INTEREST
+	rate.Version = INTEREST_DOC_VERSION
+	rate.UpdatedTx = stub.GetTxID()
+	rate.UpdatedAt = timestamp
+	bytes, err := json.Marshal(rate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_INTEREST_RATE_SET, InterestRateEvent{Asset: rate.Asset, Account: rate.Account, Rate: rate})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// parseRate - parse annual rate in percent
+func parseRate(val string) (Amount, error) {
+	rate, err := ParseCanonicalAmount(val)
+	if err != nil || rate.Sign() < 0 || rate.Cmp(AmountFromInt(100)) > 0 {
+		return Amount{}, fmt.Errorf(`Invalid rate, expecting a percentage between 0 and 100. (actual: "%s")`, val)
+	}
+	return rate, nil
+}
+
+// setInterestRate: set annual interest rate in percent of asset paid out of pool account (admin only),
+// <asset> <rate> <pool>. An empty rate stops accrual at the asset rate, an empty pool keeps the current
+// one. The replaced rate is kept in the history of the rate, so that balances earn it until the change
+// on their next accrual; only MAX_RATE_HISTORY earlier rates are kept.
+func (t *BalanceManager) setInterestRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set interest rate.")
+	}
+
+	asset, resp := loadAsset(stub, args[0])
+	if asset == nil {
+		return resp
+	}
+	current, err := getInterestRate(stub, asset.Code, "")
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	rate := ZeroAmount(0)
+	if args[1] != "" {
+		rate, err = parseRate(args[1])
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+	} else if current == nil {
+		return errorResponse(ERR_INTEREST_NOT_FOUND, fmt.Sprintf(`Interest rate not found. (Asset: "%s", Account: "")`, asset.Code))
+	}
+
+	poolName := args[2]
+	if poolName == "" && current != nil {
+		poolName = current.Pool
+	}
+	// pool must be able to pay the asset
+	pool, _, resp := loadBalance(stub, poolName, asset)
+	if pool == nil {
+		return resp
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	next := &InterestRate{Asset: asset.Code, Rate: rate, Pool: pool.Name, Since: timestamp}
+	if current != nil {
+		next.History = append(current.History, &RatePeriod{Rate: current.Rate, Since: current.Since, Until: timestamp})
+		if len(next.History) > MAX_RATE_HISTORY {
+			next.History = next.History[len(next.History)-MAX_RATE_HISTORY:]
+		}
+	}
+	return putInterestRate(stub, next, false)
+}
+
+// setAccountInterestRate: override interest rate of asset for one account (admin only),
+// <account> <asset> <rate>. An empty rate removes the override. Interest earned at the replaced rate
+// is accrued first.
+func (t *BalanceManager) setAccountInterestRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set interest rate.")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	cache := newAccountCache(stub)
+	account, _, resp := cachedBalance(cache, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	var rate Amount
+	if args[2] != "" {
+		var err error
+		rate, err = parseRate(args[2])
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+
+		// the override is paid by the pool of the asset rate
+		assetRate, err := getInterestRate(stub, asset.Code, "")
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if assetRate == nil {
+			return errorResponse(ERR_INTEREST_NOT_FOUND, fmt.Sprintf(`Interest rate of asset must be set first. (Asset: "%s")`, asset.Code))
+		}
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, asset, account)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	if args[2] == "" {
+		return putInterestRate(stub, &InterestRate{Asset: asset.Code, Account: account.Name}, true)
+	}
+	return putInterestRate(stub, &InterestRate{Asset: asset.Code, Account: account.Name, Rate: rate}, false)
+}
+
+// queryInterestRate: query rate of asset applying to account, <asset> [account]
+func (t *BalanceManager) queryInterestRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	rate, _, err := interestTerms(stub, args[0], optionalArg(args, 1))
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if rate == nil {
+		return errorResponse(ERR_INTEREST_NOT_FOUND, fmt.Sprintf(`Interest rate not found. (Asset: "%s", Account: "%s")`, args[0], optionalArg(args, 1)))
+	}
+
+	bytes, err := json.Marshal(rate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// accrue: post interest earned by balance of account (any caller), <account> <asset>
+func (t *BalanceManager) accrue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	cache := newAccountCache(stub)
+	account, balance, resp := cachedBalance(cache, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	interest, resp := accrueInterest(stub, cache, journal, account, asset, now)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	accrual := Accrual{Account: account.Name, Asset: asset.Code, Interest: interest, Balance: balance.Amount}
+	err = emitEvent(stub, EVENT_INTEREST_ACCRUED, InterestAccruedEvent{Accruals: []*Accrual{&accrual}})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	bytes, err := json.Marshal(accrual)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// accrueAll: post interest of every balance of asset (admin only), at most limit accounts after the
+// named one in account order, <asset> <limit> [after]. The report names the account to continue after.
+func (t *BalanceManager) accrueAll(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to accrue interest of every account.")
+	}
+
+	asset, resp := loadAsset(stub, args[0])
+	if asset == nil {
+		return resp
+	}
+	limit, err := parsePageSize(args[1])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	after := optionalArg(args, 2)
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	// names are collected first, the pool may be loaded through the cache while iterating
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_ACCOUNT, []string{})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
+
+	names := make([]string, 0)
+	more := false
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		account, err := ParseAccount(kv.Value)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if account.Name <= after || account.GetBalance(asset.Code) == nil {
+			continue
+		}
+		if len(names) == int(limit) {
+			more = true
+			break
+		}
+		names = append(names, account.Name)
+	}
+
+	cache := newAccountCache(stub)
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	report := AccrualReport{Accruals: make([]*Accrual, 0, len(names))}
+	for _, name := range names {
+		account, balance, resp := cachedBalance(cache, name, asset)
+		if account == nil {
+			return resp
+		}
+		interest, resp := accrueInterest(stub, cache, journal, account, asset, now)
+		accrual := Accrual{Account: name, Asset: asset.Code, Interest: interest, Balance: balance.Amount}
+		if resp.Status != shim.OK {
+			payload := parseErrorPayload(resp)
+			if payload.Code == ERR_LEDGER {
+				return resp
+			}
+			accrual.Error = payload.Message
+		}
+		report.Accruals = append(report.Accruals, &accrual)
+	}
+	if more {
+		report.Next = names[len(names)-1]
+	}
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_INTEREST_ACCRUED, InterestAccruedEvent{Accruals: report.Accruals})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
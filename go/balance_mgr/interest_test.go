@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ComputeInterest(t *testing.T) {
+	principal, _ := ParseAmount("1000.00", 2)
+	rate, _ := ParseCanonicalAmount("5")
+
+	// a full year earns the rate, rounded down at the scale of principal
+	assert.Equal(t, "50.00", computeInterest(principal, rate, SECONDS_PER_YEAR).String())
+	assert.Equal(t, "0.13", computeInterest(principal, rate, 24*60*60).String())
+
+	fractional, _ := ParseCanonicalAmount("2.5")
+	assert.Equal(t, "25.00", computeInterest(principal, fractional, SECONDS_PER_YEAR).String())
+
+	negative, _ := ParseAmount("-10.00", 2)
+	assert.Equal(t, "0.00", computeInterest(negative, rate, SECONDS_PER_YEAR).String())
+	assert.Equal(t, "0.00", computeInterest(principal, rate, 0).String())
+}
+
+func Test_PendingInterest(t *testing.T) {
+	amount, _ := ParseAmount("1000.00", 2)
+	rate, _ := ParseCanonicalAmount("5")
+	terms := &InterestRate{Rate: rate}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	balance := Balance{Amount: amount}
+
+	// accrual starts on the first touch after a rate is set
+	assert.Equal(t, "0.00", pendingInterest(&balance, terms, start.Add(time.Hour), 2).String())
+
+	balance.AccruedAt = start.Format(TIMESTAMP_FORMAT)
+	assert.Equal(t, "50.00", pendingInterest(&balance, terms, start.Add(365*24*time.Hour), 2).String())
+	assert.Equal(t, "0.00", pendingInterest(&balance, terms, start.Add(time.Minute), 2).String())
+
+	// fractions carried from earlier accruals add up to whole units
+	owed := owedInterest(&balance, terms, start.Add(time.Minute), 2)
+	assert.Equal(t, "0.00009512937", owed.String())
+	carried, _ := ParseCanonicalAmount("0.00999999999")
+	balance.AccruedInterest = &carried
+	assert.Equal(t, "0.01", pendingInterest(&balance, terms, start.Add(time.Minute), 2).String())
+}
+
+func Test_SplitInterest(t *testing.T) {
+	owed, _ := ParseCanonicalAmount("12.34567")
+	whole, fraction := splitInterest(owed, 2)
+	assert.Equal(t, "12.34", whole.String())
+	assert.Equal(t, "0.00567", fraction.String())
+
+	whole, fraction = splitInterest(AmountFromInt(3), 2)
+	assert.Equal(t, "3.00", whole.String())
+	assert.Equal(t, 0, fraction.Sign())
+}
+
+func Test_InterestOver(t *testing.T) {
+	principal, _ := ParseAmount("1000.00", 2)
+	five, _ := ParseCanonicalAmount("5")
+	ten, _ := ParseCanonicalAmount("10")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	year := 365 * 24 * time.Hour
+	change := start.Add(year).Format(TIMESTAMP_FORMAT)
+
+	// a year at 5 before the change, a year at 10 after it
+	rate := &InterestRate{Rate: ten, Since: change, History: []*RatePeriod{{Rate: five, Until: change}}}
+	assert.Equal(t, "150.00", interestOver(principal, rate, start, start.Add(2*year)).String())
+	assert.Equal(t, "50.00", interestOver(principal, rate, start, start.Add(year)).String())
+	assert.Equal(t, "100.00", interestOver(principal, rate, start.Add(year), start.Add(2*year)).String())
+}
+
+func Test_ParseRate(t *testing.T) {
+	rate, err := parseRate("3.75")
+	assert.Nil(t, err)
+	assert.Equal(t, "3.75", rate.String())
+
+	for _, val := range []string{"-1", "100.01", "abc", ""} {
+		_, err = parseRate(val)
+		assert.NotNil(t, err, val)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// KEY_PREFIX_JOURNAL - journal entries are kept under simple keys ordered as account~timestamp~txid~seq,
+	// parts separated by 0x00 which composite keys do not allow in account names. Range queries refuse
+	// composite keys, so that a time range of an account is queried as a key range.
+	KEY_PREFIX_JOURNAL = "JOURNAL_"
+	JOURNAL_SEPARATOR  = "\x00"
+)
+
+type EntryType string
+
+const (
+	ENTRY_OPEN         EntryType = "OPEN"
+	ENTRY_CHARGE       EntryType = "CHARGE"
+	ENTRY_MINT         EntryType = "MINT"
+	ENTRY_BURN         EntryType = "BURN"
+	ENTRY_TRANSFER_OUT EntryType = "TRANSFER_OUT"
+	ENTRY_TRANSFER_IN  EntryType = "TRANSFER_IN"
+	ENTRY_FEE          EntryType = "FEE"
+	ENTRY_FEE_IN       EntryType = "FEE_IN"
+	ENTRY_EXCHANGE_OUT EntryType = "EXCHANGE_OUT"
+	ENTRY_EXCHANGE_IN  EntryType = "EXCHANGE_IN"
+	ENTRY_STATUS       EntryType = "STATUS"
+	ENTRY_HOLD         EntryType = "HOLD"
+	ENTRY_HOLD_RELEASE EntryType = "HOLD_RELEASE"
+	ENTRY_HOLD_CANCEL  EntryType = "HOLD_CANCEL"
+	ENTRY_SHIELD       EntryType = "SHIELD"
+	ENTRY_UNSHIELD     EntryType = "UNSHIELD"
+	ENTRY_ORDER_FAILED EntryType = "ORDER_FAILED"
+	ENTRY_ORDER_CANCEL EntryType = "ORDER_CANCEL"
+
+	ENTRY_INTEREST      EntryType = "INTEREST"
+	ENTRY_INTEREST_PAID EntryType = "INTEREST_PAID"
+)
+
+// JournalEntry - one movement of an account, stored under key 'account~timestamp~txid~seq'
+type JournalEntry struct {
+	AbstractDoc
+	Account      string        `json:"account"`
+	TxID         string        `json:"tx_id"`
+	Seq          int           `json:"seq"`
+	Type         EntryType     `json:"type"`
+	Asset        string        `json:"asset"`
+	Counterparty string        `json:"counterparty"`
+	Amount       Amount        `json:"amount"`
+	Balance      Amount        `json:"balance"`
+	Held         *Amount       `json:"held,omitempty"`
+	Reference    string        `json:"reference,omitempty"`
+	Status       AccountStatus `json:"status,omitempty"`
+	Actor        Identity      `json:"actor"`
+	Timestamp    string        `json:"timestamp"`
+	Memo         string        `json:"memo"`
+}
+
+// Journal - appends entries of current transaction, several entries of one account are told apart by sequence
+type Journal struct {
+	stub      shim.ChaincodeStubInterface
+	actor     Identity
+	timestamp string
+	seq       map[string]int
+}
+
+// newJournal - journal of current transaction, entries are recorded with the creator as actor
+func newJournal(stub shim.ChaincodeStubInterface) (*Journal, error) {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := creatorIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{stub: stub, actor: actor, timestamp: timestamp, seq: make(map[string]int)}, nil
+}
+
+// Append - record movement of account, amount is signed and balance is the resulting balance
+func (t *Journal) Append(entryType EntryType, account string, assetCode string, counterparty string, amount Amount, balance Amount, memo string) error {
+	return t.AppendRef(entryType, account, assetCode, counterparty, amount, balance, "", memo)
+}
+
+// AppendRef - record movement of account caused by the referenced document, such as a standing order
+func (t *Journal) AppendRef(entryType EntryType, account string, assetCode string, counterparty string, amount Amount, balance Amount, reference string, memo string) error {
+	entry := t.newEntry(entryType, account, memo)
+	entry.Asset = assetCode
+	entry.Counterparty = counterparty
+	entry.Amount = amount
+	entry.Balance = balance
+	entry.Reference = reference
+	return t.put(entry)
+}
+
+// AppendHold - record change of held amount by hold, amount is the signed change of balance
+func (t *Journal) AppendHold(entryType EntryType, account string, assetCode string, counterparty string, amount Amount, balance *Balance, holdID string, memo string) error {
+	entry := t.newEntry(entryType, account, memo)
+	entry.Asset = assetCode
+	entry.Counterparty = counterparty
+	entry.Amount = amount
+	entry.Balance = balance.Amount
+	held := balance.Held
+	entry.Held = &held
+	entry.Reference = holdID
+	return t.put(entry)
+}
+
+// AppendStatus - record status change of account with the reason given by actor
+func (t *Journal) AppendStatus(account string, status AccountStatus, reason string) error {
+	entry := t.newEntry(ENTRY_STATUS, account, reason)
+	entry.Status = status
+	return t.put(entry)
+}
+
+func (t *Journal) newEntry(entryType EntryType, account string, memo string) *JournalEntry {
+	entry := JournalEntry{
+		Account:   account,
+		TxID:      t.stub.GetTxID(),
+		Seq:       t.seq[account],
+		Type:      entryType,
+		Actor:     t.actor,
+		Timestamp: t.timestamp,
+		Memo:      memo,
+	}
+	entry.DocType = DOC_JOURNAL
+	entry.Version = JOURNAL_DOC_VERSION
+	t.seq[account] = entry.Seq + 1
+	return &entry
+}
+
+// journalKey - key of entry, sorted by account, timestamp, tx id and sequence
+func journalKey(entry *JournalEntry) string {
+	return journalPrefix(entry.Account) + strings.Join([]string{entry.Timestamp, entry.TxID, fmt.Sprintf("%04d", entry.Seq)}, JOURNAL_SEPARATOR)
+}
+
+func journalPrefix(accountName string) string {
+	return KEY_PREFIX_JOURNAL + accountName + JOURNAL_SEPARATOR
+}
+
+// journalRange - start and end key of entries of account within [from, to), both in TIMESTAMP_FORMAT.
+// An empty bound leaves the range open.
+func journalRange(accountName string, from string, to string) (string, string) {
+	prefix := journalPrefix(accountName)
+	end := KEY_PREFIX_JOURNAL + accountName + "\x01"
+	if to != "" {
+		end = prefix + to
+	}
+	return prefix + from, end
+}
+
+func (t *Journal) put(entry *JournalEntry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return t.stub.PutState(journalKey(entry), bytes)
+}
+
+// ParseJournalEntry - parse journal entry document
+func ParseJournalEntry(data []byte) (*JournalEntry, error) {
+	entry := JournalEntry{}
+	err := json.Unmarshal(data, &entry)
+	if err != nil || entry.DocType != DOC_JOURNAL {
+		return nil, fmt.Errorf(`invalid journal entry. (value: "%s")`, string(data))
+	}
+	if entry.Version > JOURNAL_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported journal entry version. (expecting <= %d, actual: %d)`, JOURNAL_DOC_VERSION, entry.Version)
+	}
+	return &entry, nil
+}
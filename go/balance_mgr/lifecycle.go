@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// notActive - error response if account is frozen or closed
+func notActive(account *Account) (pb.Response, bool) {
+	switch account.Status {
+	case STATUS_ACTIVE:
+		return shim.Success(nil), false
+	case STATUS_FROZEN:
+		return errorResponse(ERR_ACCOUNT_FROZEN, fmt.Sprintf(`Account is frozen. (Account: "%s")`, account.Name)), true
+	case STATUS_CLOSED:
+		return errorResponse(ERR_ACCOUNT_CLOSED, fmt.Sprintf(`Account is closed. (Account: "%s")`, account.Name)), true
+	}
+	return errorResponse(ERR_LEDGER, fmt.Sprintf(`Unknown account status. (Account: "%s", status: "%s")`, account.Name, account.Status)), true
+}
+
+// freeze: freeze active account pending investigation (admin only)
+func (t *BalanceManager) freeze(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeStatus(stub, args, STATUS_ACTIVE, STATUS_FROZEN)
+}
+
+// unfreeze: reactivate frozen account (admin only)
+func (t *BalanceManager) unfreeze(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeStatus(stub, args, STATUS_FROZEN, STATUS_ACTIVE)
+}
+
+func (t *BalanceManager) changeStatus(stub shim.ChaincodeStubInterface, args []string, from AccountStatus, to AccountStatus) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to change account status.")
+	}
+
+	accountName := args[0]
+	reason := args[1]
+	if reason == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Reason must not be empty.")
+	}
+
+	account, err := getAccount(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if account.Status != from {
+		return errorResponse(ERR_INVALID_STATUS, fmt.Sprintf(`Invalid account status. (Account: "%s", expecting: "%s", actual: "%s")`, accountName, from, account.Status))
+	}
+
+	account.Status = to
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.AppendStatus(accountName, to, reason)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	eventType := EVENT_FROZEN
+	if to == STATUS_ACTIVE {
+		eventType = EVENT_UNFROZEN
+	}
+	err = emitEvent(stub, eventType, StatusEvent{Account: accountName, Status: to, Reason: reason})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// close: close active or frozen account (admin only). No amount may be held, every balance must be zero, or the positive
+// balances are swept into the named sweep account which must hold the same assets.
+func (t *BalanceManager) close(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to change account status.")
+	}
+
+	accountName := args[0]
+	reason := args[1]
+	sweepName := optionalArg(args, 2)
+	if reason == "" {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Reason must not be empty.")
+	}
+	if sweepName == accountName {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Sweep into the closing account is not allowed. (Account: "%s")`, accountName))
+	}
+
+	cache := newAccountCache(stub)
+	account, err := cache.getAccount(accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, accountName))
+	}
+	if account.Status == STATUS_CLOSED {
+		return errorResponse(ERR_ACCOUNT_CLOSED, fmt.Sprintf(`Account is closed. (Account: "%s")`, accountName))
+	}
+
+	var sweep *Account
+	if sweepName != "" {
+		sweep, err = cache.getAccount(sweepName)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if sweep == nil {
+			return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, sweepName))
+		}
+		if resp, inactive := notActive(sweep); inactive {
+			return resp
+		}
+	}
+
+	pending, err := hasPendingCredits(stub, accountName)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if pending {
+		return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Confidential credits must be claimed to close. (Account: "%s")`, accountName))
+	}
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	// sorted, so that every endorser writes the same journal sequence
+	assetCodes := make([]string, 0, len(account.Balances))
+	for assetCode := range account.Balances {
+		assetCodes = append(assetCodes, assetCode)
+	}
+	sort.Strings(assetCodes)
+
+	swept := make(map[string]Amount)
+	for _, assetCode := range assetCodes {
+		asset, err := cache.getAsset(assetCode)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if asset == nil {
+			return errorResponse(ERR_ASSET_NOT_FOUND, fmt.Sprintf(`Asset not registered. (Asset: "%s")`, assetCode))
+		}
+		balance, resp := accountBalance(account, asset)
+		if balance == nil {
+			return resp
+		}
+		if balance.Held.Sign() != 0 {
+			return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Open holds must be released or cancelled to close. (Account: "%s", asset: "%s", held: %s)`,
+				accountName, assetCode, balance.Held))
+		}
+		shielded, err := hasPrivateBalance(stub, account, assetCode)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if shielded {
+			return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Confidential balance must be unshielded to close. (Account: "%s", asset: "%s")`, accountName, assetCode))
+		}
+		// interest earned until closing is paid before the balance is swept
+		resp = accrueTouched(stub, cache, journal, asset, account, sweep)
+		if resp.Status != shim.OK {
+			return resp
+		}
+		if balance.Amount.Sign() == 0 {
+			continue
+		}
+		if sweep == nil || balance.Amount.Sign() < 0 {
+			return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Balance must be zero or positive with a sweep account to close. (Account: "%s", asset: "%s", balance: %s)`,
+				accountName, assetCode, balance.Amount))
+		}
+		sweepBalance, resp := accountBalance(sweep, asset)
+		if sweepBalance == nil {
+			return resp
+		}
+
+		amount := balance.Amount
+		swept[assetCode] = amount
+		balance.Amount = ZeroAmount(asset.Decimals)
+		sweepBalance.Amount = sweepBalance.Amount.Add(amount)
+
+		err = journal.Append(ENTRY_TRANSFER_OUT, accountName, assetCode, sweepName, amount.Neg(), balance.Amount, reason)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = journal.Append(ENTRY_TRANSFER_IN, sweepName, assetCode, accountName, amount, sweepBalance.Amount, reason)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+
+	account.Status = STATUS_CLOSED
+	// aliases of a closed account are freed for other accounts
+	err = releaseAliases(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.AppendStatus(accountName, STATUS_CLOSED, reason)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_CLOSED, StatusEvent{Account: accountName, Status: STATUS_CLOSED, Reason: reason, Sweep: sweepName, Swept: swept})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_LIMIT       = "limit"
+	INDEX_LIMIT_USAGE = "limit~usage"
+	// INDEX_LIMIT_BREACH - refused transactions whose breach was recorded, [account, asset, tx id]
+	INDEX_LIMIT_BREACH = "limit~breach"
+
+	// LIMIT_MONTH_DAYS - days of the rolling monthly window
+	LIMIT_MONTH_DAYS = 30
+
+	// HOUR_BUCKET_FORMAT, DAY_BUCKET_FORMAT - keys of usage buckets in UTC, sorted as strings
+	HOUR_BUCKET_FORMAT = "2006-01-02T15"
+	DAY_BUCKET_FORMAT  = "2006-01-02"
+)
+
+// LimitScope - what a spending limit applies to, one account or every account of a class
+type LimitScope string
+
+const (
+	LIMIT_SCOPE_ACCOUNT LimitScope = "account"
+	LIMIT_SCOPE_CLASS   LimitScope = "class"
+)
+
+// LimitWindow - window of a spending limit
+type LimitWindow string
+
+const (
+	LIMIT_PER_TX  LimitWindow = "PER_TX"
+	LIMIT_DAILY   LimitWindow = "DAILY"
+	LIMIT_MONTHLY LimitWindow = "MONTHLY"
+)
+
+// SpendingLimit - outgoing limits of asset for an account or an account class, stored under composite
+// key of 'limit'. The limit of an account replaces the limit of its class, missing amounts do not limit.
+type SpendingLimit struct {
+	AbstractDoc
+	Asset     string     `json:"asset"`
+	Scope     LimitScope `json:"scope"`
+	Name      string     `json:"name"`
+	PerTx     *Amount    `json:"per_tx,omitempty"`
+	Daily     *Amount    `json:"daily,omitempty"`
+	Monthly   *Amount    `json:"monthly,omitempty"`
+	UpdatedTx string     `json:"updated_tx"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+// LimitUsage - outgoing amounts of a limited account, in hourly buckets of the rolling day and daily
+// buckets of the rolling month, stored under composite key 'limit~usage'. Usage is counted only while
+// a limit applies. Amounts moved between confidential balances are unseen, so shielding counts instead.
+type LimitUsage struct {
+	AbstractDoc
+	Account      string            `json:"account"`
+	Asset        string            `json:"asset"`
+	Hours        map[string]Amount `json:"hours"`
+	Days         map[string]Amount `json:"days"`
+	Breaches     int               `json:"breaches"`
+	LastBreachAt string            `json:"last_breach_at,omitempty"`
+}
+
+// LimitBreach - window of limit an outgoing amount would exceed, also payload of LimitExceeded.
+// TxID is the transaction refused for the breach.
+type LimitBreach struct {
+	Account string      `json:"account"`
+	Asset   string      `json:"asset"`
+	Window  LimitWindow `json:"window"`
+	Limit   Amount      `json:"limit"`
+	Used    Amount      `json:"used"`
+	Amount  Amount      `json:"amount"`
+	TxID    string      `json:"tx_id,omitempty"`
+}
+
+// LimitStatus - limit applying to account with its usage of the rolling windows, returned by spendingLimit
+type LimitStatus struct {
+	Limit   *SpendingLimit `json:"limit"`
+	Daily   Amount         `json:"daily"`
+	Monthly Amount         `json:"monthly"`
+}
+
+// ParseSpendingLimit - parse spending limit document
+func ParseSpendingLimit(data []byte) (*SpendingLimit, error) {
+	limit := SpendingLimit{}
+	err := json.Unmarshal(data, &limit)
+	if err != nil || limit.DocType != DOC_LIMIT {
+		return nil, fmt.Errorf(`invalid spending limit document. (value: "%s")`, string(data))
+	}
+	if limit.Version > LIMIT_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported spending limit document version. (expecting <= %d, actual: %d)`, LIMIT_DOC_VERSION, limit.Version)
+	}
+	return &limit, nil
+}
+
+// ParseLimitUsage - parse limit usage document
+func ParseLimitUsage(data []byte) (*LimitUsage, error) {
+	usage := LimitUsage{}
+	err := json.Unmarshal(data, &usage)
+	if err != nil || usage.DocType != DOC_LIMIT_USAGE {
+		return nil, fmt.Errorf(`invalid limit usage document. (value: "%s")`, string(data))
+	}
+	if usage.Version > LIMIT_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported limit usage document version. (expecting <= %d, actual: %d)`, LIMIT_DOC_VERSION, usage.Version)
+	}
+	return &usage, nil
+}
+
+// NewLimitUsage - generate usage of account without any outgoing amount
+func NewLimitUsage(accountName string, assetCode string) *LimitUsage {
+	usage := LimitUsage{Account: accountName, Asset: assetCode, Hours: make(map[string]Amount), Days: make(map[string]Amount)}
+	usage.DocType = DOC_LIMIT_USAGE
+	usage.Version = LIMIT_DOC_VERSION
+	return &usage
+}
+
+// Normalize - validate limit and rescale its amounts to the decimals of asset
+func (t *SpendingLimit) Normalize(decimals int) error {
+	for _, field := range []**Amount{&t.PerTx, &t.Daily, &t.Monthly} {
+		if *field == nil {
+			continue
+		}
+		amount, err := (*field).Rescale(decimals)
+		if err != nil {
+			return err
+		}
+		if amount.Sign() < 0 {
+			return fmt.Errorf("negative limit. (actual: %s)", amount)
+		}
+		*field = &amount
+	}
+	if t.PerTx == nil && t.Daily == nil && t.Monthly == nil {
+		return fmt.Errorf("at least one of per_tx, daily and monthly required")
+	}
+	return nil
+}
+
+// firstHour, firstDay - oldest buckets within the rolling windows ending at now
+func firstHour(now time.Time) string {
+	return now.UTC().Add(-23 * time.Hour).Format(HOUR_BUCKET_FORMAT)
+}
+
+func firstDay(now time.Time) string {
+	return now.UTC().AddDate(0, 0, 1-LIMIT_MONTH_DAYS).Format(DAY_BUCKET_FORMAT)
+}
+
+// prune - drop buckets which left the rolling windows
+func (t *LimitUsage) prune(now time.Time) {
+	since := firstHour(now)
+	for bucket := range t.Hours {
+		if bucket < since {
+			delete(t.Hours, bucket)
+		}
+	}
+	since = firstDay(now)
+	for bucket := range t.Days {
+		if bucket < since {
+			delete(t.Days, bucket)
+		}
+	}
+}
+
+// used - outgoing amounts within the rolling day and month ending at now
+func (t *LimitUsage) used(now time.Time, scale int) (Amount, Amount) {
+	daily := ZeroAmount(scale)
+	since := firstHour(now)
+	for bucket, amount := range t.Hours {
+		if bucket >= since {
+			daily = daily.Add(amount)
+		}
+	}
+	monthly := ZeroAmount(scale)
+	since = firstDay(now)
+	for bucket, amount := range t.Days {
+		if bucket >= since {
+			monthly = monthly.Add(amount)
+		}
+	}
+	return daily, monthly
+}
+
+// add - count outgoing amount at now
+func (t *LimitUsage) add(amount Amount, now time.Time) {
+	t.prune(now)
+	hour := now.UTC().Format(HOUR_BUCKET_FORMAT)
+	t.Hours[hour] = amount.Add(t.Hours[hour])
+	day := now.UTC().Format(DAY_BUCKET_FORMAT)
+	t.Days[day] = amount.Add(t.Days[day])
+}
+
+// check - first window of limit the outgoing amount would exceed, nil if within limits
+func (t *SpendingLimit) check(usage *LimitUsage, amount Amount, now time.Time) *LimitBreach {
+	daily, monthly := usage.used(now, amount.Scale())
+	windows := []struct {
+		window LimitWindow
+		limit  *Amount
+		used   Amount
+	}{
+		{LIMIT_PER_TX, t.PerTx, ZeroAmount(amount.Scale())},
+		{LIMIT_DAILY, t.Daily, daily},
+		{LIMIT_MONTHLY, t.Monthly, monthly},
+	}
+	for _, window := range windows {
+		if window.limit != nil && window.used.Add(amount).Cmp(*window.limit) > 0 {
+			return &LimitBreach{Account: usage.Account, Asset: usage.Asset, Window: window.window, Limit: *window.limit, Used: window.used, Amount: amount}
+		}
+	}
+	return nil
+}
+
+func limitKey(stub shim.ChaincodeStubInterface, assetCode string, scope LimitScope, name string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_LIMIT, []string{assetCode, string(scope), name})
+}
+
+// getSpendingLimit - load limit of asset for scope, nil if not set
+func getSpendingLimit(stub shim.ChaincodeStubInterface, assetCode string, scope LimitScope, name string) (*SpendingLimit, error) {
+	key, err := limitKey(stub, assetCode, scope, name)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseSpendingLimit(valBytes)
+}
+
+// accountLimit - limit of asset applying to account, the limit of the account or else of its class
+func accountLimit(stub shim.ChaincodeStubInterface, account *Account, assetCode string) (*SpendingLimit, error) {
+	limit, err := getSpendingLimit(stub, assetCode, LIMIT_SCOPE_ACCOUNT, account.Name)
+	if err != nil || limit != nil || account.Class == "" {
+		return limit, err
+	}
+	return getSpendingLimit(stub, assetCode, LIMIT_SCOPE_CLASS, account.Class)
+}
+
+func usageKey(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_LIMIT_USAGE, []string{accountName, assetCode})
+}
+
+// getLimitUsage - load usage of account, empty if nothing was counted yet
+func getLimitUsage(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (*LimitUsage, error) {
+	key, err := usageKey(stub, accountName, assetCode)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return NewLimitUsage(accountName, assetCode), nil
+	}
+	return ParseLimitUsage(valBytes)
+}
+
+// putLimitUsage - write usage back to ledger
+func putLimitUsage(stub shim.ChaincodeStubInterface, usage *LimitUsage) error {
+	key, err := usageKey(stub, usage.Account, usage.Asset)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// limitExceeded - error response of a breach. Fabric drops events of refused proposals, so the client
+// reports the breach with the refused tx id by recordLimitBreach to have LimitExceeded committed.
+func limitExceeded(breach *LimitBreach) pb.Response {
+	return errorResponse(ERR_LIMIT_EXCEEDED, fmt.Sprintf(`Spending limit exceeded. (Account: "%s", asset: "%s", window: %s, limit: %s, used: %s, amount: %s, tx: "%s")`,
+		breach.Account, breach.Asset, breach.Window, breach.Limit, breach.Used, breach.Amount, breach.TxID))
+}
+
+// spendingTracker - usage counted within one transaction. GetState does not see writes of the current
+// transaction, so every outgoing amount of an invoke must be counted through one tracker and flushed once.
+type spendingTracker struct {
+	stub   shim.ChaincodeStubInterface
+	usages map[string]*LimitUsage
+	order  []string
+}
+
+func newSpendingTracker(stub shim.ChaincodeStubInterface) *spendingTracker {
+	return &spendingTracker{stub: stub, usages: make(map[string]*LimitUsage), order: make([]string, 0)}
+}
+
+// spend - count outgoing amount of account against the limit applying to it, the error response is
+// returned if a window would be exceeded
+func (t *spendingTracker) spend(account *Account, asset *Asset, amount Amount) pb.Response {
+	limit, err := accountLimit(t.stub, account, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if limit == nil {
+		return shim.Success(nil)
+	}
+	now, err := txTime(t.stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	key := account.Name + "\x00" + asset.Code
+	usage, ok := t.usages[key]
+	if !ok {
+		usage, err = getLimitUsage(t.stub, account.Name, asset.Code)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+	if breach := limit.check(usage, amount, now); breach != nil {
+		breach.TxID = t.stub.GetTxID()
+		return limitExceeded(breach)
+	}
+	usage.add(amount, now)
+	if !ok {
+		t.usages[key] = usage
+		t.order = append(t.order, key)
+	}
+	return shim.Success(nil)
+}
+
+// flush - write every counted usage back to ledger in counting order
+func (t *spendingTracker) flush() error {
+	for _, key := range t.order {
+		err := putLimitUsage(t.stub, t.usages[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSpendingLimit: set outgoing limits of asset for an account or an account class from a JSON
+// document such as '{"per_tx":"100","daily":"500","monthly":"2000"}' (admin only),
+// <asset> <'account'|'class'> <name> <limit>. An empty document removes the limit.
+func (t *BalanceManager) setSpendingLimit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 4")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set spending limit.")
+	}
+
+	asset, resp := loadAsset(stub, args[0])
+	if asset == nil {
+		return resp
+	}
+	scope := LimitScope(args[1])
+	name := args[2]
+	switch scope {
+	case LIMIT_SCOPE_ACCOUNT:
+		account, _, resp := loadBalance(stub, name, asset)
+		if account == nil {
+			return resp
+		}
+	case LIMIT_SCOPE_CLASS:
+		if name == "" {
+			return errorResponse(ERR_INVALID_ARGUMENT, "Account class must not be empty.")
+		}
+	default:
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid scope, expecting "%s" or "%s". (actual: "%s")`, LIMIT_SCOPE_ACCOUNT, LIMIT_SCOPE_CLASS, scope))
+	}
+	key, err := limitKey(stub, asset.Code, scope, name)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	event := SpendingLimitEvent{Asset: asset.Code, Scope: scope, Name: name}
+	if args[3] == "" {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	} else {
+		limit := SpendingLimit{}
+		err = json.Unmarshal([]byte(args[3]), &limit)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid spending limit, expecting a JSON document. cause: (%s)", err))
+		}
+		err = limit.Normalize(asset.Decimals)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid spending limit. cause: (%s)", err))
+		}
+		timestamp, err := txTimestamp(stub)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		limit.DocType = DOC_LIMIT
+		limit.Version = LIMIT_DOC_VERSION
+		limit.Asset = asset.Code
+		limit.Scope = scope
+		limit.Name = name
+		limit.UpdatedTx = stub.GetTxID()
+		limit.UpdatedAt = timestamp
+		bytes, err := json.Marshal(limit)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(key, bytes)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		event.Limit = &limit
+	}
+
+	err = emitEvent(stub, EVENT_SPENDING_LIMIT_SET, event)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// setAccountClass: assign account to a class sharing spending limits (admin only), <account> <class>.
+// An empty class removes the account from its class.
+func (t *BalanceManager) setAccountClass(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set account class.")
+	}
+
+	account, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if account == nil {
+		return errorResponse(ERR_ACCOUNT_NOT_FOUND, fmt.Sprintf(`Account not found. (Account: "%s")`, args[0]))
+	}
+
+	account.Class = args[1]
+	err = putAccount(stub, account)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ACCOUNT_CLASS_SET, AccountClassEvent{Account: account.Name, Class: account.Class})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// querySpendingLimit: query limit of asset applying to account and its usage (owner or admin only),
+// <account> <asset>
+func (t *BalanceManager) querySpendingLimit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	if !isAdmin(stub) {
+		err := authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+
+	limit, err := accountLimit(stub, account, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if limit == nil {
+		return errorResponse(ERR_LIMIT_NOT_FOUND, fmt.Sprintf(`Spending limit not found. (Account: "%s", Asset: "%s")`, account.Name, asset.Code))
+	}
+	usage, err := getLimitUsage(stub, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	daily, monthly := usage.used(now, asset.Decimals)
+
+	bytes, err := json.Marshal(LimitStatus{Limit: limit, Daily: daily, Monthly: monthly})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// limitBreachKey - key marking the breach of refused transaction as recorded
+func limitBreachKey(stub shim.ChaincodeStubInterface, accountName string, assetCode string, txID string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_LIMIT_BREACH, []string{accountName, assetCode, txID})
+}
+
+// recordLimitBreach: record that an outgoing amount of account was refused by its spending limit and
+// emit LimitExceeded (owner, signer of account or admin), <account> <asset> <amount> <tx id>, the tx id
+// being the one of the refused proposal named by the LimitExceeded error. Refused proposals never reach
+// the ledger, so the breach is checked again against the committed usage and each refused transaction
+// is recorded once; a breach may still be reported for a proposal which was never submitted.
+func (t *BalanceManager) recordLimitBreach(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 4")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	if !isAdmin(stub) && authorizeOwner(stub, account) != nil {
+		policy, _, resp := loadSigner(stub, account.Name, asset.Code)
+		if policy == nil {
+			if parseErrorPayload(resp).Code == ERR_LEDGER {
+				return resp
+			}
+			return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`Only the owner, a signer of account or admin is allowed to record a limit breach. (Account: "%s")`, account.Name))
+		}
+	}
+	amount, err := parseAmount(args[2], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	refusedTx := args[3]
+	if refusedTx == "" || refusedTx == stub.GetTxID() {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid tx id, expecting the id of the refused transaction. (actual: "%s")`, refusedTx))
+	}
+	breachKey, err := limitBreachKey(stub, account.Name, asset.Code, refusedTx)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	recorded, err := stub.GetState(breachKey)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if len(recorded) != 0 {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Breach already recorded. (Account: "%s", asset: "%s", tx: "%s")`, account.Name, asset.Code, refusedTx))
+	}
+
+	limit, err := accountLimit(stub, account, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if limit == nil {
+		return errorResponse(ERR_LIMIT_NOT_FOUND, fmt.Sprintf(`Spending limit not found. (Account: "%s", Asset: "%s")`, account.Name, asset.Code))
+	}
+	usage, err := getLimitUsage(stub, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	breach := limit.check(usage, amount, now)
+	if breach == nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Amount is within spending limit. (Account: "%s", asset: "%s", amount: %s)`, account.Name, asset.Code, amount))
+	}
+	breach.TxID = refusedTx
+
+	usage.prune(now)
+	usage.Breaches++
+	usage.LastBreachAt = now.Format(TIMESTAMP_FORMAT)
+	err = putLimitUsage(stub, usage)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = stub.PutState(breachKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_LIMIT_EXCEEDED, breach)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
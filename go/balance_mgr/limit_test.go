@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LimitUsageWindows(t *testing.T) {
+	usage := NewLimitUsage("a", "PTS")
+	start := time.Date(2020, 3, 1, 10, 30, 0, 0, time.UTC)
+	ten, _ := ParseAmount("10.00", 2)
+
+	usage.add(ten, start)
+	usage.add(ten, start.Add(20*time.Minute))
+	usage.add(ten, start.Add(5*time.Hour))
+	daily, monthly := usage.used(start.Add(5*time.Hour), 2)
+	assert.Equal(t, "30.00", daily.String())
+	assert.Equal(t, "30.00", monthly.String())
+
+	// the first hour leaves the rolling day, the month still counts it
+	daily, monthly = usage.used(start.Add(24*time.Hour), 2)
+	assert.Equal(t, "10.00", daily.String())
+	assert.Equal(t, "30.00", monthly.String())
+
+	later := start.AddDate(0, 0, LIMIT_MONTH_DAYS)
+	usage.add(ten, later)
+	daily, monthly = usage.used(later, 2)
+	assert.Equal(t, "10.00", daily.String())
+	assert.Equal(t, "10.00", monthly.String())
+	assert.Equal(t, 1, len(usage.Hours))
+	assert.Equal(t, 1, len(usage.Days))
+}
+
+func Test_SpendingLimitCheck(t *testing.T) {
+	limit := SpendingLimit{}
+	assert.NotNil(t, limit.Normalize(2))
+
+	perTx, _ := ParseAmount("50", 2)
+	daily, _ := ParseAmount("80", 2)
+	limit = SpendingLimit{PerTx: &perTx, Daily: &daily}
+	assert.Nil(t, limit.Normalize(2))
+
+	now := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	usage := NewLimitUsage("a", "PTS")
+	forty, _ := ParseAmount("40", 2)
+	sixty, _ := ParseAmount("60", 2)
+
+	breach := limit.check(usage, sixty, now)
+	assert.NotNil(t, breach)
+	assert.Equal(t, LIMIT_PER_TX, breach.Window)
+
+	assert.Nil(t, limit.check(usage, forty, now))
+	usage.add(forty, now)
+	assert.Nil(t, limit.check(usage, forty, now))
+	usage.add(forty, now)
+
+	breach = limit.check(usage, forty, now.Add(time.Hour))
+	assert.NotNil(t, breach)
+	assert.Equal(t, LIMIT_DAILY, breach.Window)
+	assert.Equal(t, "80.00", breach.Used.String())
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type DocumentType string
+
+const (
+	DOC_ACCOUNT   DocumentType = "ACCOUNT"
+	DOC_ASSET     DocumentType = "ASSET"
+	DOC_JOURNAL   DocumentType = "JOURNAL"
+	DOC_HOLD      DocumentType = "HOLD"
+	DOC_SUPPLY    DocumentType = "SUPPLY"
+	DOC_FEE       DocumentType = "FEE"
+	DOC_ALLOWANCE DocumentType = "ALLOWANCE"
+
+	DOC_PRIVATE_BALANCE DocumentType = "PRIVATE_BALANCE"
+	DOC_PRIVATE_CREDIT  DocumentType = "PRIVATE_CREDIT"
+	DOC_REQUEST         DocumentType = "REQUEST"
+	DOC_CONFIG          DocumentType = "CONFIG"
+	DOC_ORDER           DocumentType = "ORDER"
+	DOC_INTEREST        DocumentType = "INTEREST"
+	DOC_LIMIT           DocumentType = "LIMIT"
+	DOC_LIMIT_USAGE     DocumentType = "LIMIT_USAGE"
+	DOC_SIGNER_POLICY   DocumentType = "SIGNER_POLICY"
+	DOC_PROPOSAL        DocumentType = "PROPOSAL"
+)
+
+type AccountStatus string
+
+const (
+	STATUS_ACTIVE AccountStatus = "ACTIVE"
+	STATUS_FROZEN AccountStatus = "FROZEN"
+	STATUS_CLOSED AccountStatus = "CLOSED"
+)
+
+const (
+	// MAX_DECIMALS - maximum decimals of registered asset
+	MAX_DECIMALS = 18
+	// ACCOUNT_DOC_VERSION - current version of account document layout, bare integer strings predate documents
+	ACCOUNT_DOC_VERSION = 3
+	// ASSET_DOC_VERSION - current version of asset document layout
+	ASSET_DOC_VERSION = 1
+	// JOURNAL_DOC_VERSION - current version of journal entry layout
+	JOURNAL_DOC_VERSION = 1
+	// HOLD_DOC_VERSION - current version of hold document layout
+	HOLD_DOC_VERSION = 1
+	// SUPPLY_DOC_VERSION - current version of supply document layout
+	SUPPLY_DOC_VERSION = 1
+	// FEE_DOC_VERSION - current version of fee schedule document layout
+	FEE_DOC_VERSION = 1
+	// ALLOWANCE_DOC_VERSION - current version of allowance document layout
+	ALLOWANCE_DOC_VERSION = 1
+	// PRIVATE_DOC_VERSION - current version of private balance and credit layouts
+	PRIVATE_DOC_VERSION = 1
+	// REQUEST_DOC_VERSION - current version of request registry layout
+	REQUEST_DOC_VERSION = 1
+	// CONFIG_DOC_VERSION - current version of config document layout
+	CONFIG_DOC_VERSION = 1
+	// ORDER_DOC_VERSION - current version of standing order layout
+	ORDER_DOC_VERSION = 1
+	// INTEREST_DOC_VERSION - current version of interest rate layout
+	INTEREST_DOC_VERSION = 1
+	// LIMIT_DOC_VERSION - current version of spending limit and usage layouts
+	LIMIT_DOC_VERSION = 1
+	// MULTISIG_DOC_VERSION - current version of signer policy and transfer proposal layouts
+	MULTISIG_DOC_VERSION = 1
+	// MAX_PAGE_SIZE - maximum page size of paginated queries
+	MAX_PAGE_SIZE = 200
+	// DEFAULT_CURRENCY - asset of migrated legacy accounts
+	DEFAULT_CURRENCY = "DEFAULT"
+)
+
+type AbstractDoc struct {
+	DocType DocumentType `json:"doc_type"`
+	Version int          `json:"version"`
+}
+
+// Identity - client identity resolved by cid
+type Identity struct {
+	ID    string `json:"id"`
+	MSPID string `json:"msp_id"`
+}
+
+// Equals - same identity issued by the same MSP
+func (t Identity) Equals(other Identity) bool {
+	return t.ID == other.ID && t.MSPID == other.MSPID
+}
+
+// Asset - asset registered by code, stored under composite key of 'asset'
+type Asset struct {
+	AbstractDoc
+	Code      string `json:"code"`
+	Decimals  int    `json:"decimals"`
+	IssuerMSP string `json:"issuer_msp"`
+	CreatedTx string `json:"created_tx"`
+	CreatedAt string `json:"created_at"`
+}
+
+// NewAsset - generate an asset document
+func NewAsset(code string, decimals int, issuerMSP string) *Asset {
+	asset := Asset{Code: code, Decimals: decimals, IssuerMSP: issuerMSP}
+	asset.DocType = DOC_ASSET
+	asset.Version = ASSET_DOC_VERSION
+	return &asset
+}
+
+// ParseAsset - parse asset document
+func ParseAsset(data []byte) (*Asset, error) {
+	asset := Asset{}
+	err := json.Unmarshal(data, &asset)
+	if err != nil || asset.DocType != DOC_ASSET {
+		return nil, fmt.Errorf(`invalid asset document. (value: "%s")`, string(data))
+	}
+	if asset.Version > ASSET_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported asset document version. (expecting <= %d, actual: %d)`, ASSET_DOC_VERSION, asset.Version)
+	}
+	return &asset, nil
+}
+
+// Supply - issued and burned amounts of an asset, stored under composite key of 'supply'.
+// An empty MaxSupply leaves issuing unlimited. Shielded is the part of circulating amount held
+// in confidential balances, empty if never shielded.
+type Supply struct {
+	AbstractDoc
+	Asset     string  `json:"asset"`
+	Issued    Amount  `json:"issued"`
+	Burned    Amount  `json:"burned"`
+	MaxSupply *Amount `json:"max_supply,omitempty"`
+	Shielded  *Amount `json:"shielded,omitempty"`
+	UpdatedTx string  `json:"updated_tx"`
+	UpdatedAt string  `json:"updated_at"`
+}
+
+// NewSupply - generate a supply document with nothing issued
+func NewSupply(assetCode string, decimals int, maxSupply *Amount) *Supply {
+	supply := Supply{Asset: assetCode, Issued: ZeroAmount(decimals), Burned: ZeroAmount(decimals), MaxSupply: maxSupply}
+	supply.DocType = DOC_SUPPLY
+	supply.Version = SUPPLY_DOC_VERSION
+	return &supply
+}
+
+// ParseSupply - parse supply document
+func ParseSupply(data []byte) (*Supply, error) {
+	supply := Supply{}
+	err := json.Unmarshal(data, &supply)
+	if err != nil || supply.DocType != DOC_SUPPLY {
+		return nil, fmt.Errorf(`invalid supply document. (value: "%s")`, string(data))
+	}
+	if supply.Version > SUPPLY_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported supply document version. (expecting <= %d, actual: %d)`, SUPPLY_DOC_VERSION, supply.Version)
+	}
+	return &supply, nil
+}
+
+// Circulating - amount issued and not burned
+func (t *Supply) Circulating() Amount {
+	return t.Issued.Sub(t.Burned)
+}
+
+// ShieldedAmount - amount held in confidential balances
+func (t *Supply) ShieldedAmount() Amount {
+	if t.Shielded == nil {
+		return ZeroAmount(t.Issued.Scale())
+	}
+	return *t.Shielded
+}
+
+// CanIssue - check whether amount can be issued without exceeding MaxSupply
+func (t *Supply) CanIssue(amount Amount) bool {
+	return t.MaxSupply == nil || t.Circulating().Add(amount).Cmp(*t.MaxSupply) <= 0
+}
+
+// SupplyView - supply of asset reported by query
+type SupplyView struct {
+	Asset       string  `json:"asset"`
+	Issued      Amount  `json:"issued"`
+	Burned      Amount  `json:"burned"`
+	Circulating Amount  `json:"circulating"`
+	Shielded    Amount  `json:"shielded"`
+	MaxSupply   *Amount `json:"max_supply,omitempty"`
+}
+
+// NewSupplyView - generate supply view
+func NewSupplyView(supply *Supply) *SupplyView {
+	return &SupplyView{
+		Asset:       supply.Asset,
+		Issued:      supply.Issued,
+		Burned:      supply.Burned,
+		Circulating: supply.Circulating(),
+		Shielded:    supply.ShieldedAmount(),
+		MaxSupply:   supply.MaxSupply,
+	}
+}
+
+// Balance - balance of one asset held by an account, amounts carry the decimals of the asset.
+// Held is the part of Amount locked by holds, missing in documents written before holds existed.
+// AccruedAt is the time interest was last accrued, empty while the balance earns no interest.
+// AccruedInterest is interest earned but not paid yet, below one unit or left unpaid by the pool.
+type Balance struct {
+	Amount          Amount  `json:"amount"`
+	CreditLimit     Amount  `json:"credit_limit"`
+	Held            Amount  `json:"held"`
+	AccruedAt       string  `json:"accrued_at,omitempty"`
+	AccruedInterest *Amount `json:"accrued_interest,omitempty"`
+}
+
+// Available - amount not locked by holds
+func (t *Balance) Available() Amount {
+	return t.Amount.Sub(t.Held)
+}
+
+// CanDebit - check whether amount can be taken from available amount without going below -CreditLimit
+func (t *Balance) CanDebit(amount Amount) bool {
+	return t.Available().Sub(amount).Cmp(t.CreditLimit.Neg()) >= 0
+}
+
+// Normalize - rescale amounts to the decimals of asset, error if stored value does not fit
+func (t *Balance) Normalize(decimals int) error {
+	amount, err := t.Amount.Rescale(decimals)
+	if err != nil {
+		return err
+	}
+	creditLimit, err := t.CreditLimit.Rescale(decimals)
+	if err != nil {
+		return err
+	}
+	held, err := t.Held.Rescale(decimals)
+	if err != nil {
+		return err
+	}
+	t.Amount = amount
+	t.CreditLimit = creditLimit
+	t.Held = held
+	return nil
+}
+
+// Account - account document stored under composite key 'account', KYC reference and memo are kept as
+// ciphertext tagged with the key version. Aliases are unique across accounts, sorted. Class groups
+// accounts sharing spending limits.
+type Account struct {
+	AbstractDoc
+	Name      string              `json:"name"`
+	Owner     Identity            `json:"owner"`
+	Balances  map[string]*Balance `json:"balances"`
+	Status    AccountStatus       `json:"status"`
+	Aliases   []string            `json:"aliases,omitempty"`
+	Class     string              `json:"class,omitempty"`
+	KYCRef    string              `json:"kyc_ref,omitempty"`
+	Memo      string              `json:"memo,omitempty"`
+	CreatedTx string              `json:"created_tx"`
+	CreatedAt string              `json:"created_at"`
+	UpdatedTx string              `json:"updated_tx"`
+	UpdatedAt string              `json:"updated_at"`
+}
+
+// NewAccount - generate an active account without any balance
+func NewAccount(name string, owner Identity) *Account {
+	account := Account{Name: name, Owner: owner, Status: STATUS_ACTIVE}
+	account.DocType = DOC_ACCOUNT
+	account.Version = ACCOUNT_DOC_VERSION
+	account.Balances = make(map[string]*Balance)
+	return &account
+}
+
+// ParseAccount - parse account document of the current version, legacy integer-string values are
+// rejected and migrated by upgrade instead
+func ParseAccount(data []byte) (*Account, error) {
+	doc := AbstractDoc{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil || doc.DocType != DOC_ACCOUNT {
+		return nil, fmt.Errorf(`invalid account document. (value: "%s")`, string(data))
+	}
+	if doc.Version > ACCOUNT_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported account document version. (expecting <= %d, actual: %d)`, ACCOUNT_DOC_VERSION, doc.Version)
+	}
+	if doc.Version < ACCOUNT_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported account document version. (expecting %d, actual: %d)`, ACCOUNT_DOC_VERSION, doc.Version)
+	}
+
+	account := Account{}
+	err = json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid account document. cause: (%s)`, err)
+	}
+	// a missing amount would decode as zero, only held may be absent from balances written before holds
+	fields := struct {
+		Balances map[string]map[string]json.RawMessage `json:"balances"`
+	}{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid account document. cause: (%s)`, err)
+	}
+	for assetCode, balance := range fields.Balances {
+		for _, field := range []string{"amount", "credit_limit"} {
+			if _, ok := balance[field]; !ok {
+				return nil, fmt.Errorf(`invalid balance of account, %s missing. (account: "%s", asset: "%s")`, field, account.Name, assetCode)
+			}
+		}
+	}
+	if account.Balances == nil {
+		account.Balances = make(map[string]*Balance)
+	}
+	return &account, nil
+}
+
+// GetBalance - balance of asset, nil if the asset is not opened for account
+func (t *Account) GetBalance(assetCode string) *Balance {
+	return t.Balances[assetCode]
+}
+
+// OpenBalance - open balance of asset with amount of 0
+func (t *Account) OpenBalance(assetCode string, decimals int) *Balance {
+	balance := &Balance{Amount: ZeroAmount(decimals), CreditLimit: ZeroAmount(decimals), Held: ZeroAmount(decimals)}
+	t.Balances[assetCode] = balance
+	return balance
+}
+
+// ParseLegacyBalance - parse balance stored by earlier versions as a bare integer string
+func ParseLegacyBalance(data []byte) (Amount, bool) {
+	balance, err := ParseAmount(string(data), 0)
+	if err != nil {
+		return Amount{}, false
+	}
+	return balance, true
+}
+
+// BalanceView - balance of one asset of an account returned by query, with interest earned but not yet accrued
+type BalanceView struct {
+	Name        string        `json:"name"`
+	Asset       string        `json:"asset"`
+	Amount      Amount        `json:"amount"`
+	Held        Amount        `json:"held"`
+	Available   Amount        `json:"available"`
+	CreditLimit Amount        `json:"credit_limit"`
+	Interest    *Amount       `json:"interest,omitempty"`
+	Status      AccountStatus `json:"status"`
+}
+
+// NewBalanceView - generate view of balance of asset held by account
+func NewBalanceView(account *Account, assetCode string, balance *Balance) *BalanceView {
+	return &BalanceView{
+		Name:        account.Name,
+		Asset:       assetCode,
+		Amount:      balance.Amount,
+		Held:        balance.Held,
+		Available:   balance.Available(),
+		CreditLimit: balance.CreditLimit,
+		Status:      account.Status,
+	}
+}
+
+// PageTitle - pagination metadata of a page
+type PageTitle struct {
+	Count    int32  `json:"count"`
+	Bookmark string `json:"bookmark"`
+}
+
+// Page - one page of a paginated query
+type Page struct {
+	PageTitle PageTitle   `json:"page_title"`
+	PageData  interface{} `json:"page_data"`
+}
+
+// Touch - record the transaction which updated the account
+func (t *Account) Touch(txID string, timestamp string) {
+	if t.CreatedTx == "" {
+		t.CreatedTx = txID
+		t.CreatedAt = timestamp
+	}
+	t.UpdatedTx = txID
+	t.UpdatedAt = timestamp
+}
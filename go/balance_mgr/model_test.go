@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseAccount(t *testing.T) {
+	account := NewAccount("a", Identity{ID: "user1", MSPID: "Org1MSP"})
+	account.OpenBalance(DEFAULT_CURRENCY, 2).Amount, _ = ParseAmount("100", 2)
+	bytes, _ := json.Marshal(account)
+
+	parsed, err := ParseAccount(bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, account, parsed)
+
+	_, err = ParseAccount([]byte("100"))
+	assert.NotNil(t, err, "legacy balance is not an account document.")
+
+	account.Version = ACCOUNT_DOC_VERSION + 1
+	bytes, _ = json.Marshal(account)
+	_, err = ParseAccount(bytes)
+	assert.NotNil(t, err, "newer document version should be rejected.")
+
+	data := `{"doc_type":"ACCOUNT","version":1,"name":"a","currency":"PTS","balance":30,"credit_limit":10,"status":"ACTIVE"}`
+	_, err = ParseAccount([]byte(data))
+	assert.NotNil(t, err, "single currency layout was never stored.")
+
+	data = `{"doc_type":"ACCOUNT","version":2,"name":"a","balances":{"PTS":{"amount":-5,"credit_limit":10}},"status":"ACTIVE"}`
+	_, err = ParseAccount([]byte(data))
+	assert.NotNil(t, err, "integer amounts were never stored.")
+
+	data = `{"doc_type":"ACCOUNT","version":3,"name":"a","balances":{"PTS":{"amount":"x1","credit_limit":"0"}},"status":"ACTIVE"}`
+	_, err = ParseAccount([]byte(data))
+	assert.NotNil(t, err, "corrupted balance should fail loudly.")
+
+	for _, balances := range []string{`{"PTS":{}}`, `{"PTS":null}`, `{"PTS":{"amount":"1"}}`, `{"PTS":{"credit_limit":"0"}}`} {
+		data = `{"doc_type":"ACCOUNT","version":3,"name":"a","balances":` + balances + `,"status":"ACTIVE"}`
+		_, err = ParseAccount([]byte(data))
+		assert.NotNil(t, err, "missing balance fields should fail loudly, not read as zero. "+balances)
+	}
+
+	data = `{"doc_type":"ACCOUNT","version":3,"name":"a","balances":{"PTS":{"amount":"1","credit_limit":"0"}},"status":"ACTIVE"}`
+	account, err = ParseAccount([]byte(data))
+	assert.Nil(t, err, "held is missing in balances written before holds.")
+	assert.Equal(t, 0, account.GetBalance("PTS").Held.Sign())
+}
+
+func Test_ParseLegacyBalance(t *testing.T) {
+	balance, ok := ParseLegacyBalance([]byte("-20"))
+	assert.True(t, ok)
+	assert.Equal(t, "-20", balance.String())
+
+	_, ok = ParseLegacyBalance([]byte(`{"doc_type":"ACCOUNT"}`))
+	assert.False(t, ok)
+}
+
+func Test_BalanceHeld(t *testing.T) {
+	balance := Balance{Amount: AmountFromInt(30), CreditLimit: AmountFromInt(10), Held: AmountFromInt(25)}
+	assert.Equal(t, "5", balance.Available().String())
+	assert.True(t, balance.CanDebit(AmountFromInt(15)))
+	assert.False(t, balance.CanDebit(AmountFromInt(16)), "held amount is not available.")
+}
+
+func Test_Supply(t *testing.T) {
+	maxSupply := AmountFromInt(100)
+	supply := NewSupply("PTS", 0, &maxSupply)
+	supply.Issued = AmountFromInt(120)
+	supply.Burned = AmountFromInt(30)
+	assert.Equal(t, "90", supply.Circulating().String())
+	assert.True(t, supply.CanIssue(AmountFromInt(10)))
+	assert.False(t, supply.CanIssue(AmountFromInt(11)), "max supply exceeded.")
+
+	supply.MaxSupply = nil
+	assert.True(t, supply.CanIssue(AmountFromInt(1000000)), "unlimited without max supply.")
+
+	data := `{"doc_type":"SUPPLY","version":1,"asset":"PTS","issued":"5.00","burned":"1.50"}`
+	supply, err := ParseSupply([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "3.50", supply.Circulating().String())
+	assert.Nil(t, supply.MaxSupply)
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_SIGNER_POLICY = "signers"
+	INDEX_PROPOSAL      = "proposal"
+
+	// DEFAULT_PROPOSAL_TTL - time a proposal stays open unless the signer policy sets one
+	DEFAULT_PROPOSAL_TTL = 72 * time.Hour
+)
+
+// ProposalStatus - stage of a transfer proposal, an open proposal past expiry is reported as expired
+type ProposalStatus string
+
+const (
+	PROPOSAL_PENDING  ProposalStatus = "PENDING"
+	PROPOSAL_APPROVED ProposalStatus = "APPROVED"
+	PROPOSAL_EXECUTED ProposalStatus = "EXECUTED"
+	PROPOSAL_REJECTED ProposalStatus = "REJECTED"
+	PROPOSAL_EXPIRED  ProposalStatus = "EXPIRED"
+)
+
+// SignerPolicy - M-of-N approval of amounts of an asset above threshold leaving an account, stored under
+// composite key of 'signers'. Such amounts can only leave the account by an approved proposal.
+type SignerPolicy struct {
+	AbstractDoc
+	Account   string     `json:"account"`
+	Asset     string     `json:"asset"`
+	Signers   []Identity `json:"signers"`
+	Required  int        `json:"required"`
+	Threshold Amount     `json:"threshold"`
+	TTL       string     `json:"ttl,omitempty"`
+	UpdatedTx string     `json:"updated_tx"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+// TransferProposal - transfer waiting for approval of the signers of the paying account, identified by
+// the tx id which proposed it
+type TransferProposal struct {
+	AbstractDoc
+	ID         string         `json:"id"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Asset      string         `json:"asset"`
+	Amount     Amount         `json:"amount"`
+	Memo       string         `json:"memo,omitempty"`
+	Proposer   Identity       `json:"proposer"`
+	Approvals  []Identity     `json:"approvals"`
+	Rejections []Identity     `json:"rejections"`
+	Status     ProposalStatus `json:"status"`
+	Expiry     string         `json:"expiry"`
+	CreatedAt  string         `json:"created_at"`
+	ExecutedTx string         `json:"executed_tx,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+}
+
+// ParseSignerPolicy - parse signer policy document
+func ParseSignerPolicy(data []byte) (*SignerPolicy, error) {
+	policy := SignerPolicy{}
+	err := json.Unmarshal(data, &policy)
+	if err != nil || policy.DocType != DOC_SIGNER_POLICY {
+		return nil, fmt.Errorf(`invalid signer policy document. (value: "%s")`, string(data))
+	}
+	if policy.Version > MULTISIG_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported signer policy document version. (expecting <= %d, actual: %d)`, MULTISIG_DOC_VERSION, policy.Version)
+	}
+	return &policy, nil
+}
+
+// ParseTransferProposal - parse transfer proposal document
+func ParseTransferProposal(data []byte) (*TransferProposal, error) {
+	proposal := TransferProposal{}
+	err := json.Unmarshal(data, &proposal)
+	if err != nil || proposal.DocType != DOC_PROPOSAL {
+		return nil, fmt.Errorf(`invalid transfer proposal document. (value: "%s")`, string(data))
+	}
+	if proposal.Version > MULTISIG_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported transfer proposal document version. (expecting <= %d, actual: %d)`, MULTISIG_DOC_VERSION, proposal.Version)
+	}
+	return &proposal, nil
+}
+
+// Normalize - validate policy and rescale its threshold to the decimals of asset
+func (t *SignerPolicy) Normalize(decimals int) error {
+	if len(t.Signers) == 0 {
+		return fmt.Errorf("signers required")
+	}
+	for idx, signer := range t.Signers {
+		if signer.ID == "" || signer.MSPID == "" {
+			return fmt.Errorf("signer id and MSP id must not be empty. (signer: %d)", idx)
+		}
+		for _, other := range t.Signers[:idx] {
+			if other.Equals(signer) {
+				return fmt.Errorf(`duplicate signer. (signer: "%s" of "%s")`, signer.ID, signer.MSPID)
+			}
+		}
+	}
+	if t.Required < 1 || t.Required > len(t.Signers) {
+		return fmt.Errorf("invalid required approvals, expecting 1 to %d. (actual: %d)", len(t.Signers), t.Required)
+	}
+	threshold, err := t.Threshold.Rescale(decimals)
+	if err != nil {
+		return err
+	}
+	if threshold.Sign() < 0 {
+		return fmt.Errorf("negative threshold. (actual: %s)", threshold)
+	}
+	t.Threshold = threshold
+	if t.TTL != "" {
+		ttl, err := time.ParseDuration(t.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf(`invalid ttl, expecting a positive duration such as "72h". (actual: "%s")`, t.TTL)
+		}
+		t.TTL = ttl.String()
+	}
+	return nil
+}
+
+// IsSigner - check whether identity belongs to the signer set
+func (t *SignerPolicy) IsSigner(identity Identity) bool {
+	for _, signer := range t.Signers {
+		if signer.Equals(identity) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers - check whether amount needs approval
+func (t *SignerPolicy) Covers(amount Amount) bool {
+	return amount.Cmp(t.Threshold) > 0
+}
+
+// countSigners - identities of the list still in the signer set
+func (t *SignerPolicy) countSigners(identities []Identity) int {
+	count := 0
+	for _, identity := range identities {
+		if t.IsSigner(identity) {
+			count++
+		}
+	}
+	return count
+}
+
+// signed - check whether identity approved or rejected proposal
+func (t *TransferProposal) signed(identity Identity) bool {
+	for _, list := range [][]Identity{t.Approvals, t.Rejections} {
+		for _, signer := range list {
+			if signer.Equals(identity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expired - check whether an open proposal can no longer be signed at time
+func (t *TransferProposal) expired(now time.Time) bool {
+	return t.Expiry <= now.UTC().Format(TIMESTAMP_FORMAT)
+}
+
+// signerPolicyKey - key of signer policy of account for asset
+func signerPolicyKey(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_SIGNER_POLICY, []string{accountName, assetCode})
+}
+
+// getSignerPolicy - load signer policy of account for asset, nil if not set
+func getSignerPolicy(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (*SignerPolicy, error) {
+	key, err := signerPolicyKey(stub, accountName, assetCode)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseSignerPolicy(valBytes)
+}
+
+// getProposal - load transfer proposal, nil if not existing
+func getProposal(stub shim.ChaincodeStubInterface, proposalID string) (*TransferProposal, error) {
+	key, err := stub.CreateCompositeKey(INDEX_PROPOSAL, []string{proposalID})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseTransferProposal(valBytes)
+}
+
+// putProposal - write transfer proposal back to ledger
+func putProposal(stub shim.ChaincodeStubInterface, proposal *TransferProposal) error {
+	key, err := stub.CreateCompositeKey(INDEX_PROPOSAL, []string{proposal.ID})
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// requireApproval - error response if amount leaving account needs approval of its signers
+func requireApproval(stub shim.ChaincodeStubInterface, accountName string, asset *Asset, amount Amount) pb.Response {
+	policy, err := getSignerPolicy(stub, accountName, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if policy != nil && policy.Covers(amount) {
+		return errorResponse(ERR_APPROVAL_REQUIRED, fmt.Sprintf(`Amount above threshold needs approval of %d signers, use proposeTransfer. (Account: "%s", asset: "%s", threshold: %s, amount: %s)`,
+			policy.Required, accountName, asset.Code, policy.Threshold, amount))
+	}
+	return shim.Success(nil)
+}
+
+// loadSigner - signer policy of the paying account of proposal and the creator, the error response is
+// returned unless the creator is one of its signers
+func loadSigner(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (*SignerPolicy, Identity, pb.Response) {
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return nil, creator, errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+	}
+	policy, err := getSignerPolicy(stub, accountName, assetCode)
+	if err != nil {
+		return nil, creator, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if policy == nil || !policy.IsSigner(creator) {
+		return nil, creator, errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`creator is not a signer of account. (account: "%s", asset: "%s", creator: "%s" of "%s")`,
+			accountName, assetCode, creator.ID, creator.MSPID))
+	}
+	return policy, creator, shim.Success(nil)
+}
+
+// loadOpenProposal - proposal which can still be signed at time, the error response is returned otherwise
+func loadOpenProposal(stub shim.ChaincodeStubInterface, proposalID string, now time.Time) (*TransferProposal, pb.Response) {
+	proposal, err := getProposal(stub, proposalID)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if proposal == nil {
+		return nil, errorResponse(ERR_PROPOSAL_NOT_FOUND, fmt.Sprintf(`Transfer proposal not found. (proposal: "%s")`, proposalID))
+	}
+	if proposal.Status != PROPOSAL_PENDING && proposal.Status != PROPOSAL_APPROVED {
+		return nil, errorResponse(ERR_INVALID_STATUS, fmt.Sprintf(`Transfer proposal is closed. (proposal: "%s", status: "%s")`, proposal.ID, proposal.Status))
+	}
+	if proposal.expired(now) {
+		return nil, errorResponse(ERR_PROPOSAL_EXPIRED, fmt.Sprintf(`Transfer proposal expired. (proposal: "%s", expiry: "%s")`, proposal.ID, proposal.Expiry))
+	}
+	return proposal, shim.Success(nil)
+}
+
+// executeProposal - execute proposal once the current signers met the required approvals. A failed
+// transfer leaves the proposal approved with the error recorded, so that it can be executed again.
+func executeProposal(stub shim.ChaincodeStubInterface, policy *SignerPolicy, proposal *TransferProposal) (*TransferEvent, pb.Response) {
+	if policy.countSigners(proposal.Approvals) < policy.Required {
+		return nil, shim.Success(nil)
+	}
+	proposal.Status = PROPOSAL_APPROVED
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	return applyProposal(stub, newAccountCache(stub), journal, proposal)
+}
+
+// applyProposal - transfer of approved proposal through cache. Interest accrued by moveFunds is already
+// journaled when the transfer fails, so the cache is flushed either way to keep balances in line with
+// the journal.
+func applyProposal(stub shim.ChaincodeStubInterface, cache *accountCache, journal *Journal, proposal *TransferProposal) (*TransferEvent, pb.Response) {
+	asset, err := cache.getAsset(proposal.Asset)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if asset == nil {
+		return nil, errorResponse(ERR_ASSET_NOT_FOUND, fmt.Sprintf(`Asset not registered. (Asset: "%s")`, proposal.Asset))
+	}
+	event, resp := moveFunds(stub, cache, journal, proposal.From, proposal.To, asset, proposal.Amount, proposal.ID, proposal.Memo)
+	if event == nil {
+		payload := parseErrorPayload(resp)
+		if payload.Code == ERR_LEDGER {
+			return nil, resp
+		}
+		proposal.LastError = payload.Message
+	}
+	err = cache.flush()
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if event == nil {
+		return nil, shim.Success(nil)
+	}
+	proposal.Status = PROPOSAL_EXECUTED
+	proposal.ExecutedTx = stub.GetTxID()
+	proposal.LastError = ""
+	return event, shim.Success(nil)
+}
+
+// setSignerPolicy: set M-of-N approval of amounts of asset above threshold leaving account from a JSON
+// document such as '{"signers":[{"id":"x","msp_id":"Org1MSP"}],"required":2,"threshold":"1000","ttl":"72h"}'
+// (admin only), <account> <asset> <policy>. An empty document removes the policy.
+func (t *BalanceManager) setSignerPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set signer policy.")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	key, err := signerPolicyKey(stub, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	event := SignerPolicyEvent{Account: account.Name, Asset: asset.Code}
+	if args[2] == "" {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	} else {
+		policy := SignerPolicy{}
+		err = json.Unmarshal([]byte(args[2]), &policy)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid signer policy, expecting a JSON document. cause: (%s)", err))
+		}
+		err = policy.Normalize(asset.Decimals)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid signer policy. cause: (%s)", err))
+		}
+		timestamp, err := txTimestamp(stub)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		policy.DocType = DOC_SIGNER_POLICY
+		policy.Version = MULTISIG_DOC_VERSION
+		policy.Account = account.Name
+		policy.Asset = asset.Code
+		policy.UpdatedTx = stub.GetTxID()
+		policy.UpdatedAt = timestamp
+		bytes, err := json.Marshal(policy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(key, bytes)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		event.Policy = &policy
+	}
+
+	err = emitEvent(stub, EVENT_SIGNER_POLICY_SET, event)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// querySignerPolicy: query signer policy of account for asset, <account> <asset>
+func (t *BalanceManager) querySignerPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	policy, err := getSignerPolicy(stub, args[0], args[1])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if policy == nil {
+		return errorResponse(ERR_SIGNERS_NOT_FOUND, fmt.Sprintf(`Signer policy not found. (Account: "%s", Asset: "%s")`, args[0], args[1]))
+	}
+
+	bytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// proposeTransfer: propose a transfer from an account with a signer policy (signers only),
+// <from> <to> <asset> <amount> [memo]. The proposal counts as approval of the proposer and executes
+// at once if that is enough. The tx id is returned as id of the proposal.
+func (t *BalanceManager) proposeTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("propose transfer")
+
+	if len(args) != 4 && len(args) != 5 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 4 or 5")
+	}
+
+	accountFrom := args[0]
+	accountTo := args[1]
+	if accountFrom == accountTo {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Transfer to the same account is not allowed. (Account: "%s")`, accountFrom))
+	}
+	asset, resp := loadAsset(stub, args[2])
+	if asset == nil {
+		return resp
+	}
+	from, _, resp := loadBalance(stub, accountFrom, asset)
+	if from == nil {
+		return resp
+	}
+	to, _, resp := loadBalance(stub, accountTo, asset)
+	if to == nil {
+		return resp
+	}
+	if resp, inactive := notActive(from); inactive {
+		return resp
+	}
+	if resp, inactive := notActive(to); inactive {
+		return resp
+	}
+	amount, err := parseAmount(args[3], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+
+	policy, proposer, resp := loadSigner(stub, accountFrom, asset.Code)
+	if policy == nil {
+		return resp
+	}
+	ttl := DEFAULT_PROPOSAL_TTL
+	if policy.TTL != "" {
+		ttl, _ = time.ParseDuration(policy.TTL)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	proposal := TransferProposal{
+		ID:         stub.GetTxID(),
+		From:       accountFrom,
+		To:         accountTo,
+		Asset:      asset.Code,
+		Amount:     amount,
+		Memo:       optionalArg(args, 4),
+		Proposer:   proposer,
+		Approvals:  []Identity{proposer},
+		Rejections: []Identity{},
+		Status:     PROPOSAL_PENDING,
+		Expiry:     now.Add(ttl).Format(TIMESTAMP_FORMAT),
+		CreatedAt:  now.Format(TIMESTAMP_FORMAT),
+	}
+	proposal.DocType = DOC_PROPOSAL
+	proposal.Version = MULTISIG_DOC_VERSION
+	transfer, resp := executeProposal(stub, policy, &proposal)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	err = putProposal(stub, &proposal)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_TRANSFER_PROPOSED, ProposalEvent{Proposal: &proposal, Signer: proposer, Transfer: transfer})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success([]byte(proposal.ID))
+}
+
+// approveTransfer: approve a pending transfer proposal (signers only), executing it once the required
+// approvals are met. An approved proposal whose transfer failed is executed again.
+func (t *BalanceManager) approveTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.signProposal(stub, args, true)
+}
+
+// rejectTransfer: reject a pending transfer proposal (signers only), <proposalID> [reason]. The proposal
+// is rejected once too few signers are left to approve it.
+func (t *BalanceManager) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.signProposal(stub, args, false)
+}
+
+// signProposal - record approval or rejection of the creator, who must be a current signer
+func (t *BalanceManager) signProposal(stub shim.ChaincodeStubInterface, args []string, approve bool) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	proposal, resp := loadOpenProposal(stub, args[0], now)
+	if proposal == nil {
+		return resp
+	}
+	policy, signer, resp := loadSigner(stub, proposal.From, proposal.Asset)
+	if policy == nil {
+		return resp
+	}
+
+	eventType := EVENT_TRANSFER_APPROVED
+	var transfer *TransferEvent
+	if proposal.signed(signer) {
+		// only the execution of an approved proposal can be retried
+		if !approve || proposal.Status != PROPOSAL_APPROVED {
+			return errorResponse(ERR_INVALID_STATUS, fmt.Sprintf(`Transfer proposal already signed. (proposal: "%s", signer: "%s" of "%s")`, proposal.ID, signer.ID, signer.MSPID))
+		}
+	} else if approve {
+		proposal.Approvals = append(proposal.Approvals, signer)
+	} else {
+		eventType = EVENT_TRANSFER_REJECTED
+		proposal.Rejections = append(proposal.Rejections, signer)
+		proposal.Reason = optionalArg(args, 1)
+		if len(policy.Signers)-policy.countSigners(proposal.Rejections) < policy.Required {
+			proposal.Status = PROPOSAL_REJECTED
+		}
+	}
+	if approve {
+		transfer, resp = executeProposal(stub, policy, proposal)
+		if resp.Status != shim.OK {
+			return resp
+		}
+	}
+	err = putProposal(stub, proposal)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, eventType, ProposalEvent{Proposal: proposal, Signer: signer, Transfer: transfer})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryProposal: query transfer proposal, an open proposal past expiry is reported as expired
+func (t *BalanceManager) queryProposal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getProposal(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if proposal == nil {
+		return errorResponse(ERR_PROPOSAL_NOT_FOUND, fmt.Sprintf(`Transfer proposal not found. (proposal: "%s")`, args[0]))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if (proposal.Status == PROPOSAL_PENDING || proposal.Status == PROPOSAL_APPROVED) && proposal.expired(now) {
+		proposal.Status = PROPOSAL_EXPIRED
+	}
+
+	bytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
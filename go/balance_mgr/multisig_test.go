@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStub - world state in memory for functions using only state, composite keys, tx id and time
+type memoryStub struct {
+	shim.ChaincodeStubInterface
+	state map[string][]byte
+	txID  string
+	now   time.Time
+}
+
+func newMemoryStub(now time.Time) *memoryStub {
+	return &memoryStub{state: make(map[string][]byte), txID: "tx0", now: now}
+}
+
+func (t *memoryStub) GetTxID() string { return t.txID }
+
+func (t *memoryStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return ptypes.TimestampProto(t.now)
+}
+
+func (t *memoryStub) GetState(key string) ([]byte, error) { return t.state[key], nil }
+
+func (t *memoryStub) PutState(key string, value []byte) error {
+	t.state[key] = value
+	return nil
+}
+
+func (t *memoryStub) DelState(key string) error {
+	delete(t.state, key)
+	return nil
+}
+
+func (t *memoryStub) SetEvent(name string, payload []byte) error { return nil }
+
+func (t *memoryStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	key := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		key += attribute + "\x00"
+	}
+	return key, nil
+}
+
+// entries - journal entries of account with type
+func (t *memoryStub) entries(accountName string, entryType EntryType) []*JournalEntry {
+	entries := make([]*JournalEntry, 0)
+	for _, value := range t.state {
+		entry, err := ParseJournalEntry(value)
+		if err == nil && entry.Account == accountName && entry.Type == entryType {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func Test_SignerPolicyNormalize(t *testing.T) {
+	alice := Identity{ID: "alice", MSPID: "Org1MSP"}
+	bob := Identity{ID: "bob", MSPID: "Org1MSP"}
+	carol := Identity{ID: "carol", MSPID: "Org2MSP"}
+	threshold, _ := ParseAmount("1000", 0)
+
+	policy := SignerPolicy{Signers: []Identity{alice, bob}, Required: 3, Threshold: threshold}
+	assert.NotNil(t, policy.Normalize(2))
+
+	policy = SignerPolicy{Signers: []Identity{alice, alice}, Required: 1, Threshold: threshold}
+	assert.NotNil(t, policy.Normalize(2))
+
+	policy = SignerPolicy{Signers: []Identity{alice, bob, carol}, Required: 2, Threshold: threshold, TTL: "-1h"}
+	assert.NotNil(t, policy.Normalize(2))
+
+	policy = SignerPolicy{Signers: []Identity{alice, bob, carol}, Required: 2, Threshold: threshold, TTL: "48h"}
+	assert.Nil(t, policy.Normalize(2))
+	assert.Equal(t, "1000.00", policy.Threshold.String())
+	assert.Equal(t, "48h0m0s", policy.TTL)
+
+	small, _ := ParseAmount("1000.00", 2)
+	large, _ := ParseAmount("1000.01", 2)
+	assert.False(t, policy.Covers(small))
+	assert.True(t, policy.Covers(large))
+}
+
+func Test_ProposalApprovals(t *testing.T) {
+	alice := Identity{ID: "alice", MSPID: "Org1MSP"}
+	bob := Identity{ID: "bob", MSPID: "Org1MSP"}
+	carol := Identity{ID: "carol", MSPID: "Org2MSP"}
+	policy := SignerPolicy{Signers: []Identity{alice, bob, carol}, Required: 2}
+
+	now := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	proposal := TransferProposal{
+		Approvals: []Identity{alice},
+		Expiry:    now.Add(DEFAULT_PROPOSAL_TTL).Format(TIMESTAMP_FORMAT),
+	}
+	assert.True(t, proposal.signed(alice))
+	assert.False(t, proposal.signed(bob))
+	assert.Equal(t, 1, policy.countSigners(proposal.Approvals))
+
+	// approvals of removed signers no longer count
+	proposal.Approvals = append(proposal.Approvals, bob)
+	policy.Signers = []Identity{alice, carol}
+	assert.Equal(t, 1, policy.countSigners(proposal.Approvals))
+
+	assert.False(t, proposal.expired(now))
+	assert.True(t, proposal.expired(now.Add(DEFAULT_PROPOSAL_TTL)))
+}
+
+func Test_ApplyProposalAfterFailure(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stub := newMemoryStub(start)
+	owner := Identity{ID: "alice", MSPID: "Org1MSP"}
+	amount := func(val string) Amount {
+		a, _ := ParseAmount(val, 2)
+		return a
+	}
+	asset := NewAsset("PTS", 2, "Org1MSP")
+	assert.Nil(t, putAsset(stub, asset))
+	for name, val := range map[string]string{"pool": "1000", "alice": "100", "bob": "0"} {
+		account := NewAccount(name, owner)
+		balance := account.OpenBalance(asset.Code, asset.Decimals)
+		balance.Amount = amount(val)
+		balance.AccruedAt = start.Format(TIMESTAMP_FORMAT)
+		assert.Nil(t, putAccount(stub, account))
+	}
+	rate, _ := ParseCanonicalAmount("5")
+	assert.Equal(t, int32(200), putInterestRate(stub, &InterestRate{Asset: asset.Code, Rate: rate, Pool: "pool"}, false).Status)
+	balanceOf := func(name string) *Balance {
+		account, err := getAccount(stub, name)
+		assert.Nil(t, err)
+		return account.GetBalance(asset.Code)
+	}
+	apply := func(txID string, val string) (*TransferProposal, *TransferEvent) {
+		stub.txID = txID
+		journal := &Journal{stub: stub, actor: owner, timestamp: stub.now.Format(TIMESTAMP_FORMAT), seq: make(map[string]int)}
+		proposal := &TransferProposal{ID: "p1", From: "alice", To: "bob", Asset: asset.Code, Amount: amount(val), Status: PROPOSAL_APPROVED}
+		event, resp := applyProposal(stub, newAccountCache(stub), journal, proposal)
+		assert.Equal(t, int32(200), resp.Status)
+		return proposal, event
+	}
+
+	// the failed transfer still posts the interest it journaled
+	stub.now = start.Add(365 * 24 * time.Hour)
+	proposal, event := apply("tx1", "200")
+	assert.Nil(t, event)
+	assert.NotEqual(t, "", proposal.LastError)
+	assert.Equal(t, PROPOSAL_APPROVED, proposal.Status)
+	assert.Equal(t, "105.00", balanceOf("alice").Amount.String())
+	assert.Equal(t, stub.now.Format(TIMESTAMP_FORMAT), balanceOf("alice").AccruedAt)
+	assert.Equal(t, "995.00", balanceOf("pool").Amount.String())
+
+	// the retry does not accrue the same period again
+	proposal, event = apply("tx2", "100")
+	assert.NotNil(t, event)
+	assert.Equal(t, PROPOSAL_EXECUTED, proposal.Status)
+	assert.Equal(t, "tx2", proposal.ExecutedTx)
+	assert.Equal(t, "5.00", balanceOf("alice").Amount.String())
+	assert.Equal(t, "100.00", balanceOf("bob").Amount.String())
+	assert.Equal(t, "995.00", balanceOf("pool").Amount.String())
+	assert.Equal(t, 1, len(stub.entries("alice", ENTRY_INTEREST)))
+	assert.Equal(t, 1, len(stub.entries("pool", ENTRY_INTEREST_PAID)))
+}
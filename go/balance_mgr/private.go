@@ -0,0 +1,649 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// PRIVATE_COLLECTION_PREFIX - confidential balances live in the collection of the owner org, e.g. 'balancesOrg1MSP'
+	PRIVATE_COLLECTION_PREFIX = "balances"
+
+	INDEX_PRIVATE_BALANCE = "account~asset"
+	INDEX_PRIVATE_CREDIT  = "account~asset~credit"
+	// INDEX_PENDING_CREDIT - public index of unclaimed credits, so that any peer can tell an account has
+	// confidential funds waiting. The credit id and recipient are public by the transfer event already.
+	INDEX_PENDING_CREDIT = "pending~credit"
+
+	// TRANSIENT_AMOUNT, TRANSIENT_SALT, TRANSIENT_CREDIT_SALT - transient fields carrying amounts and salts,
+	// so that they never appear in the proposal arguments written to the ledger
+	TRANSIENT_AMOUNT      = "amount"
+	TRANSIENT_SALT        = "salt"
+	TRANSIENT_CREDIT_SALT = "credit_salt"
+
+	// MIN_SALT_LENGTH - minimum length of salt, a short salt lets the amount be guessed from the public hash
+	MIN_SALT_LENGTH = 16
+)
+
+// PrivateBalance - confidential balance of an account kept in the collection of the owner org. Only the
+// hash of the document, which the salt keeps unguessable, is committed to public state.
+type PrivateBalance struct {
+	AbstractDoc
+	Account string `json:"account"`
+	Asset   string `json:"asset"`
+	Amount  Amount `json:"amount"`
+	Salt    string `json:"salt"`
+}
+
+// PrivateCredit - confidential amount transferred to an account, written into the collection of the
+// recipient org without reading it and folded into the balance by the recipient
+type PrivateCredit struct {
+	AbstractDoc
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Account string `json:"account"`
+	Asset   string `json:"asset"`
+	Amount  Amount `json:"amount"`
+	Salt    string `json:"salt"`
+}
+
+// PrivateVerification - result of comparing a disclosed balance with the hash on the ledger
+type PrivateVerification struct {
+	Account    string `json:"account"`
+	Asset      string `json:"asset"`
+	Collection string `json:"collection"`
+	Hash       string `json:"hash"`
+	Match      bool   `json:"match"`
+}
+
+// NewPrivateBalance - generate a private balance document
+func NewPrivateBalance(accountName string, assetCode string, amount Amount, salt string) *PrivateBalance {
+	balance := PrivateBalance{Account: accountName, Asset: assetCode, Amount: amount, Salt: salt}
+	balance.DocType = DOC_PRIVATE_BALANCE
+	balance.Version = PRIVATE_DOC_VERSION
+	return &balance
+}
+
+// ParsePrivateBalance - parse private balance document
+func ParsePrivateBalance(data []byte) (*PrivateBalance, error) {
+	balance := PrivateBalance{}
+	err := json.Unmarshal(data, &balance)
+	if err != nil || balance.DocType != DOC_PRIVATE_BALANCE {
+		return nil, fmt.Errorf(`invalid private balance document`)
+	}
+	if balance.Version > PRIVATE_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported private balance document version. (expecting <= %d, actual: %d)`, PRIVATE_DOC_VERSION, balance.Version)
+	}
+	return &balance, nil
+}
+
+// ParsePrivateCredit - parse private credit document
+func ParsePrivateCredit(data []byte) (*PrivateCredit, error) {
+	credit := PrivateCredit{}
+	err := json.Unmarshal(data, &credit)
+	if err != nil || credit.DocType != DOC_PRIVATE_CREDIT {
+		return nil, fmt.Errorf(`invalid private credit document`)
+	}
+	if credit.Version > PRIVATE_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported private credit document version. (expecting <= %d, actual: %d)`, PRIVATE_DOC_VERSION, credit.Version)
+	}
+	return &credit, nil
+}
+
+// Hash - hash of the document as stored, the same value GetPrivateDataHash returns
+func (t *PrivateBalance) Hash() ([]byte, error) {
+	bytes, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(bytes)
+	return digest[:], nil
+}
+
+// privateCollection - collection of confidential balances of accounts owned by members of org
+func privateCollection(account *Account) (string, error) {
+	if account.Owner.MSPID == "" {
+		return "", fmt.Errorf(`Account has no owner org for confidential balance. (Account: "%s")`, account.Name)
+	}
+	return PRIVATE_COLLECTION_PREFIX + account.Owner.MSPID, nil
+}
+
+// getPrivateBalance - load private balance, nil if never shielded or emptied
+func getPrivateBalance(stub shim.ChaincodeStubInterface, collection string, accountName string, assetCode string) (*PrivateBalance, error) {
+	key, err := stub.CreateCompositeKey(INDEX_PRIVATE_BALANCE, []string{accountName, assetCode})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParsePrivateBalance(valBytes)
+}
+
+// putPrivateBalance - write private balance, an empty balance is removed so that no hash is left on the ledger
+func putPrivateBalance(stub shim.ChaincodeStubInterface, collection string, balance *PrivateBalance) error {
+	key, err := stub.CreateCompositeKey(INDEX_PRIVATE_BALANCE, []string{balance.Account, balance.Asset})
+	if err != nil {
+		return err
+	}
+	if balance.Amount.Sign() == 0 {
+		return stub.DelPrivateData(collection, key)
+	}
+	bytes, err := json.Marshal(balance)
+	if err != nil {
+		return err
+	}
+	return stub.PutPrivateData(collection, key, bytes)
+}
+
+// hasPrivateBalance - check by the hash on the ledger whether account holds a confidential balance of asset,
+// answered by any peer
+func hasPrivateBalance(stub shim.ChaincodeStubInterface, account *Account, assetCode string) (bool, error) {
+	if account.Owner.MSPID == "" {
+		return false, nil
+	}
+	collection, err := privateCollection(account)
+	if err != nil {
+		return false, err
+	}
+	key, err := stub.CreateCompositeKey(INDEX_PRIVATE_BALANCE, []string{account.Name, assetCode})
+	if err != nil {
+		return false, err
+	}
+	hash, err := stub.GetPrivateDataHash(collection, key)
+	if err != nil {
+		return false, err
+	}
+	return len(hash) > 0, nil
+}
+
+// hasPendingCredits - check whether credits transferred to account are left unclaimed
+func hasPendingCredits(stub shim.ChaincodeStubInterface, accountName string) (bool, error) {
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_PENDING_CREDIT, []string{accountName})
+	if err != nil {
+		return false, err
+	}
+	defer resultIt.Close()
+	return resultIt.HasNext(), nil
+}
+
+// hasShieldedFunds - check whether account holds a confidential balance of any asset or unclaimed credits,
+// both of which are kept in the collection of its owner org
+func hasShieldedFunds(stub shim.ChaincodeStubInterface, account *Account) (bool, error) {
+	for assetCode := range account.Balances {
+		shielded, err := hasPrivateBalance(stub, account, assetCode)
+		if err != nil || shielded {
+			return shielded, err
+		}
+	}
+	return hasPendingCredits(stub, account.Name)
+}
+
+func pendingCreditKey(stub shim.ChaincodeStubInterface, accountName string, assetCode string, creditID string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_PENDING_CREDIT, []string{accountName, assetCode, creditID})
+}
+
+func privateCreditKey(stub shim.ChaincodeStubInterface, accountName string, assetCode string, creditID string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_PRIVATE_CREDIT, []string{accountName, assetCode, creditID})
+}
+
+// transientAmount - positive amount of asset given in the transient map
+func transientAmount(transient map[string][]byte, asset *Asset) (Amount, error) {
+	val, ok := transient[TRANSIENT_AMOUNT]
+	if !ok {
+		return Amount{}, fmt.Errorf(`Amount must be given in transient "%s".`, TRANSIENT_AMOUNT)
+	}
+	return parseAmount(string(val), asset)
+}
+
+// transientSalt - salt given in the transient map under name
+func transientSalt(transient map[string][]byte, name string) (string, error) {
+	salt := string(transient[name])
+	if len(salt) < MIN_SALT_LENGTH {
+		return "", fmt.Errorf(`Salt of at least %d characters must be given in transient "%s".`, MIN_SALT_LENGTH, name)
+	}
+	return salt, nil
+}
+
+// loadPrivateAccount - load active account owned by the creator through cache with the collection of its
+// confidential balances
+func loadPrivateAccount(stub shim.ChaincodeStubInterface, cache *accountCache, accountName string, asset *Asset) (*Account, *Balance, string, pb.Response) {
+	account, balance, resp := cachedBalance(cache, accountName, asset)
+	if account == nil {
+		return nil, nil, "", resp
+	}
+	err := authorizeOwner(stub, account)
+	if err != nil {
+		return nil, nil, "", errorResponse(ERR_ACCESS_DENIED, err.Error())
+	}
+	if resp, inactive := notActive(account); inactive {
+		return nil, nil, "", resp
+	}
+	collection, err := privateCollection(account)
+	if err != nil {
+		return nil, nil, "", errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	return account, balance, collection, shim.Success(nil)
+}
+
+// shield: move amount from the public balance of account into its confidential balance (owner only).
+// Amount and the new salt are given in transient 'amount' and 'salt'.
+// Amounts above the signer threshold of account are refused, they can not be approved by proposal.
+func (t *BalanceManager) shield(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("shield amount of account")
+	return t.moveShielded(stub, args, true)
+}
+
+// unshield: move amount from the confidential balance of account back into its public balance (owner only).
+// Amount and the new salt are given in transient 'amount' and 'salt'.
+func (t *BalanceManager) unshield(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("unshield amount of account")
+	return t.moveShielded(stub, args, false)
+}
+
+func (t *BalanceManager) moveShielded(stub shim.ChaincodeStubInterface, args []string, shield bool) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	accountName := args[0]
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	cache := newAccountCache(stub)
+	account, balance, collection, resp := loadPrivateAccount(stub, cache, accountName, asset)
+	if account == nil {
+		return resp
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	amount, err := transientAmount(transient, asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	salt, err := transientSalt(transient, TRANSIENT_SALT)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	private, err := getPrivateBalance(stub, collection, accountName, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if private == nil {
+		private = NewPrivateBalance(accountName, asset.Code, ZeroAmount(asset.Decimals), "")
+	}
+	supply, resp := loadSupply(stub, asset)
+	if supply == nil {
+		return resp
+	}
+	shielded := supply.ShieldedAmount()
+
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, asset, account)
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	entryType, eventType := ENTRY_SHIELD, EVENT_SHIELDED
+	if shield {
+		// shielding must not draw on credit, confidential balances are never negative
+		if balance.Available().Cmp(amount) < 0 {
+			return insufficientFunds(accountName, asset.Code, balance, amount)
+		}
+		// shielded funds leave the public balance unseen, so they need approval above the threshold
+		// and count against the spending limit
+		resp = requireApproval(stub, accountName, asset, amount)
+		if resp.Status != shim.OK {
+			return resp
+		}
+		resp = cache.spending.spend(account, asset, amount)
+		if resp.Status != shim.OK {
+			return resp
+		}
+		balance.Amount = balance.Amount.Sub(amount)
+		private.Amount = private.Amount.Add(amount)
+		shielded = shielded.Add(amount)
+	} else {
+		if private.Amount.Cmp(amount) < 0 {
+			return errorResponse(ERR_INSUFFICIENT_FUNDS, fmt.Sprintf(`Insufficient confidential funds. (Account: "%s", asset: "%s")`, accountName, asset.Code))
+		}
+		entryType, eventType = ENTRY_UNSHIELD, EVENT_UNSHIELDED
+		balance.Amount = balance.Amount.Add(amount)
+		private.Amount = private.Amount.Sub(amount)
+		shielded = shielded.Sub(amount)
+	}
+	private.Salt = salt
+	supply.Shielded = &shielded
+
+	err = putPrivateBalance(stub, collection, private)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = putSupply(stub, supply)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	journalAmount := amount
+	if shield {
+		journalAmount = amount.Neg()
+	}
+	err = journal.Append(entryType, accountName, asset.Code, "", journalAmount, balance.Amount, "")
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, eventType, AmountEvent{Account: accountName, Asset: asset.Code, Amount: amount, Balance: balance.Amount})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// transferPrivate: transfer between confidential balances (owner of the sender only). Amount, the new salt
+// of the sender balance and the salt of the credit are given in transient 'amount', 'salt' and 'credit_salt'.
+// The credit is written into the collection of the recipient org and returned by its id, the tx id.
+func (t *BalanceManager) transferPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("transfer confidential amount")
+
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	fromName := args[0]
+	toName := args[1]
+	if fromName == toName {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Transfer to the same account is not allowed. (Account: "%s")`, fromName))
+	}
+	asset, resp := loadAsset(stub, args[2])
+	if asset == nil {
+		return resp
+	}
+	from, _, collection, resp := loadPrivateAccount(stub, newAccountCache(stub), fromName, asset)
+	if from == nil {
+		return resp
+	}
+	to, _, resp := loadBalance(stub, toName, asset)
+	if to == nil {
+		return resp
+	}
+	if resp, inactive := notActive(to); inactive {
+		return resp
+	}
+	toCollection, err := privateCollection(to)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	amount, err := transientAmount(transient, asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	salt, err := transientSalt(transient, TRANSIENT_SALT)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	creditSalt, err := transientSalt(transient, TRANSIENT_CREDIT_SALT)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	private, err := getPrivateBalance(stub, collection, fromName, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if private == nil || private.Amount.Cmp(amount) < 0 {
+		return errorResponse(ERR_INSUFFICIENT_FUNDS, fmt.Sprintf(`Insufficient confidential funds. (Account: "%s", asset: "%s")`, fromName, asset.Code))
+	}
+	private.Amount = private.Amount.Sub(amount)
+	private.Salt = salt
+	err = putPrivateBalance(stub, collection, private)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	credit := PrivateCredit{ID: stub.GetTxID(), From: fromName, Account: toName, Asset: asset.Code, Amount: amount, Salt: creditSalt}
+	credit.DocType = DOC_PRIVATE_CREDIT
+	credit.Version = PRIVATE_DOC_VERSION
+	key, err := privateCreditKey(stub, toName, asset.Code, credit.ID)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	bytes, err := json.Marshal(credit)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = stub.PutPrivateData(toCollection, key, bytes)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	pendingKey, err := pendingCreditKey(stub, toName, asset.Code, credit.ID)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	// an empty value would delete the key
+	err = stub.PutState(pendingKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_PRIVATE_TRANSFER, PrivateTransferEvent{From: fromName, To: toName, Asset: asset.Code, Credit: credit.ID, Collection: toCollection})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success([]byte(credit.ID))
+}
+
+// claimPrivate: fold credits into the confidential balance of account (owner only), <account> <asset> <credit>...
+// The new salt of the balance is given in transient 'salt'.
+func (t *BalanceManager) claimPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("claim confidential credits")
+
+	if len(args) < 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting at least 3")
+	}
+
+	accountName := args[0]
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, collection, resp := loadPrivateAccount(stub, newAccountCache(stub), accountName, asset)
+	if account == nil {
+		return resp
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	salt, err := transientSalt(transient, TRANSIENT_SALT)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	private, err := getPrivateBalance(stub, collection, accountName, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if private == nil {
+		private = NewPrivateBalance(accountName, asset.Code, ZeroAmount(asset.Decimals), "")
+	}
+
+	creditIDs := append([]string{}, args[2:]...)
+	sort.Strings(creditIDs)
+	for idx, creditID := range creditIDs {
+		if idx > 0 && creditIDs[idx-1] == creditID {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Duplicate credit. (credit: "%s")`, creditID))
+		}
+		key, err := privateCreditKey(stub, accountName, asset.Code, creditID)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		valBytes, err := stub.GetPrivateData(collection, key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if len(valBytes) == 0 {
+			return errorResponse(ERR_CREDIT_NOT_FOUND, fmt.Sprintf(`Credit not found. (Account: "%s", asset: "%s", credit: "%s")`, accountName, asset.Code, creditID))
+		}
+		credit, err := ParsePrivateCredit(valBytes)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		amount, err := credit.Amount.Rescale(asset.Decimals)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		private.Amount = private.Amount.Add(amount)
+		err = stub.DelPrivateData(collection, key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		pendingKey, err := pendingCreditKey(stub, accountName, asset.Code, creditID)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		err = stub.DelState(pendingKey)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+	private.Salt = salt
+	err = putPrivateBalance(stub, collection, private)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_PRIVATE_CLAIMED, PrivateClaimEvent{Account: accountName, Asset: asset.Code, Credits: creditIDs})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// privateBalance: query confidential balance of account (owner or admin), only answered by peers of the owner org
+func (t *BalanceManager) privateBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	if !isAdmin(stub) {
+		err := authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+	collection, err := privateCollection(account)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	private, err := getPrivateBalance(stub, collection, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if private == nil {
+		private = NewPrivateBalance(account.Name, asset.Code, ZeroAmount(asset.Decimals), "")
+	}
+
+	bytes, err := json.Marshal(private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// verifyPrivateBalance: check a disclosed confidential balance of account against the hash on the ledger,
+// answered by any peer. The disclosed amount and salt are given in transient 'amount' and 'salt'.
+func (t *BalanceManager) verifyPrivateBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, _, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	collection, err := privateCollection(account)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	amount, err := ParseAmount(string(transient[TRANSIENT_AMOUNT]), asset.Decimals)
+	if err != nil || amount.Sign() < 0 {
+		return errorResponse(ERR_INVALID_AMOUNT, fmt.Sprintf(`Disclosed amount must be given in transient "%s" with at most %d decimals.`, TRANSIENT_AMOUNT, asset.Decimals))
+	}
+
+	key, err := stub.CreateCompositeKey(INDEX_PRIVATE_BALANCE, []string{account.Name, asset.Code})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	onChain, err := stub.GetPrivateDataHash(collection, key)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	verification := PrivateVerification{Account: account.Name, Asset: asset.Code, Collection: collection, Hash: hex.EncodeToString(onChain)}
+	if amount.Sign() == 0 {
+		// emptied balances are removed, so no hash is left to compare
+		verification.Match = len(onChain) == 0
+	} else if len(onChain) > 0 {
+		disclosed, err := NewPrivateBalance(account.Name, asset.Code, amount, string(transient[TRANSIENT_SALT])).Hash()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		verification.Match = hex.EncodeToString(disclosed) == verification.Hash
+	}
+
+	bytes, err := json.Marshal(verification)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PrivateBalanceHash(t *testing.T) {
+	amount, _ := ParseAmount("12.50", 2)
+	balance := NewPrivateBalance("a", "PTS", amount, "0123456789abcdef")
+
+	// the hash is the digest of the stored bytes, which the ledger exposes by GetPrivateDataHash
+	stored, err := json.Marshal(balance)
+	assert.Nil(t, err)
+	digest := sha256.Sum256(stored)
+	hash, err := balance.Hash()
+	assert.Nil(t, err)
+	assert.Equal(t, digest[:], hash)
+
+	parsed, err := ParsePrivateBalance(stored)
+	assert.Nil(t, err)
+	reparsed, _ := parsed.Hash()
+	assert.Equal(t, hash, reparsed)
+
+	salted, _ := NewPrivateBalance("a", "PTS", amount, "fedcba9876543210").Hash()
+	assert.NotEqual(t, hash, salted)
+
+	_, err = ParsePrivateBalance([]byte(`{"doc_type":"PRIVATE_CREDIT","version":1}`))
+	assert.NotNil(t, err)
+}
+
+func Test_SupplyShielded(t *testing.T) {
+	supply, err := ParseSupply([]byte(`{"doc_type":"SUPPLY","version":1,"asset":"PTS","issued":"10.00","burned":"0.00"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "0.00", supply.ShieldedAmount().String())
+
+	shielded, _ := ParseAmount("4", 2)
+	supply.Shielded = &shielded
+	view := NewSupplyView(supply)
+	assert.Equal(t, "4.00", view.Shielded.String())
+	assert.Equal(t, "10.00", view.Circulating.String())
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AccountFilter - structured filter of account query, empty fields do not filter.
+// Amounts are stored as strings which CouchDB compares lexically, so the balance range
+// requires the asset and is applied to the fetched page.
+type AccountFilter struct {
+	OwnerID      string        `json:"owner_id,omitempty"`
+	OwnerMSP     string        `json:"owner_msp,omitempty"`
+	Asset        string        `json:"asset,omitempty"`
+	Status       AccountStatus `json:"status,omitempty"`
+	MinBalance   string        `json:"min_balance,omitempty"`
+	MaxBalance   string        `json:"max_balance,omitempty"`
+	UpdatedSince string        `json:"updated_since,omitempty"`
+}
+
+// escapeField - escape dots of a value used as part of a CouchDB field path
+func escapeField(val string) string {
+	return strings.Replace(val, ".", `\.`, -1)
+}
+
+// buildAccountQuery - CouchDB query of account documents matching filter
+func buildAccountQuery(filter *AccountFilter) (string, error) {
+	selector := map[string]interface{}{
+		"doc_type": DOC_ACCOUNT,
+	}
+	if filter.OwnerID != "" {
+		selector["owner.id"] = filter.OwnerID
+	}
+	if filter.OwnerMSP != "" {
+		selector["owner.msp_id"] = filter.OwnerMSP
+	}
+	if filter.Status != "" {
+		selector["status"] = filter.Status
+	}
+	if filter.Asset != "" {
+		selector["balances."+escapeField(filter.Asset)] = map[string]interface{}{"$exists": true}
+	}
+	if filter.UpdatedSince != "" {
+		since, err := time.Parse(time.RFC3339Nano, filter.UpdatedSince)
+		if err != nil {
+			return "", fmt.Errorf(`Invalid updated since, expecting RFC3339 format. (actual: "%s")`, filter.UpdatedSince)
+		}
+		selector["updated_at"] = map[string]interface{}{"$gte": since.UTC().Format(TIMESTAMP_FORMAT)}
+	}
+
+	bytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// balanceRange - parse balance range of filter, nil bounds are open
+func balanceRange(filter *AccountFilter, asset *Asset) (*Amount, *Amount, error) {
+	var bounds [2]*Amount
+	for idx, val := range []string{filter.MinBalance, filter.MaxBalance} {
+		if val == "" {
+			continue
+		}
+		amount, err := ParseAmount(val, asset.Decimals)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`Invalid balance bound, expecting a decimal value with at most %d decimals. (actual: "%s")`, asset.Decimals, val)
+		}
+		bounds[idx] = &amount
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// queryAccounts: query account documents by a JSON filter with pagination. Callers other than admin
+// only see their own accounts. A page may hold fewer accounts than page size after the balance range.
+func (t *BalanceManager) queryAccounts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	filter := AccountFilter{}
+	err := json.Unmarshal([]byte(args[0]), &filter)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid filter, expecting a JSON document. cause: (%s)", err))
+	}
+	pageSize, err := parsePageSize(args[1])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	bookmark := args[2]
+
+	if !isAdmin(stub) {
+		creator, err := creatorIdentity(stub)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+		}
+		filter.OwnerID = creator.ID
+		filter.OwnerMSP = creator.MSPID
+	}
+
+	var asset *Asset
+	var minBalance, maxBalance *Amount
+	if filter.MinBalance != "" || filter.MaxBalance != "" {
+		if filter.Asset == "" {
+			return errorResponse(ERR_INVALID_ARGUMENT, "Asset is required by balance range.")
+		}
+		var resp pb.Response
+		asset, resp = loadAsset(stub, filter.Asset)
+		if asset == nil {
+			return resp
+		}
+		minBalance, maxBalance, err = balanceRange(&filter, asset)
+		if err != nil {
+			return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+		}
+	}
+
+	query, err := buildAccountQuery(&filter)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+
+	resultIt, metadata, err := stub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
+
+	accounts := make([]*Account, 0)
+	for resultIt.HasNext() {
+		response, err := resultIt.Next()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		account, err := ParseAccount(response.Value)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if asset != nil {
+			balance, resp := accountBalance(account, asset)
+			if balance == nil {
+				return resp
+			}
+			if (minBalance != nil && balance.Amount.Cmp(*minBalance) < 0) || (maxBalance != nil && balance.Amount.Cmp(*maxBalance) > 0) {
+				continue
+			}
+		}
+		accounts = append(accounts, account)
+	}
+
+	page := Page{PageTitle: PageTitle{Count: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, PageData: accounts}
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// ownerAccounts: list accounts of an owner with pagination, <pageSize> <bookmark> [ownerID mspID].
+// The owner defaults to the creator, listing accounts of other owners is admin only.
+func (t *BalanceManager) ownerAccounts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2 or 4")
+	}
+
+	pageSize, err := parsePageSize(args[0])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	bookmark := args[1]
+
+	owner, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+	}
+	if len(args) == 4 {
+		other := Identity{ID: args[2], MSPID: args[3]}
+		if other.ID == "" || other.MSPID == "" {
+			return errorResponse(ERR_INVALID_ARGUMENT, "Owner id and MSP id must not be empty.")
+		}
+		if !other.Equals(owner) && !isAdmin(stub) {
+			return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to list accounts of other owners.")
+		}
+		owner = other
+	}
+
+	resultIt, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(INDEX_OWNER, []string{owner.MSPID, owner.ID}, pageSize, bookmark)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
+
+	accounts := make([]*Account, 0)
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		account, err := getAccount(stub, attrs[len(attrs)-1])
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if account == nil {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+
+	page := Page{PageTitle: PageTitle{Count: metadata.FetchedRecordsCount, Bookmark: metadata.Bookmark}, PageData: accounts}
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
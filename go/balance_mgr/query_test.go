@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BuildAccountQuery(t *testing.T) {
+	query, err := buildAccountQuery(&AccountFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"selector":{"doc_type":"ACCOUNT"}}`, query)
+
+	filter := AccountFilter{OwnerID: "o", OwnerMSP: "Org1MSP", Asset: "P.TS", Status: STATUS_ACTIVE, UpdatedSince: "2020-01-02T08:00:00+08:00"}
+	query, err = buildAccountQuery(&filter)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"selector":{"balances.P\\.TS":{"$exists":true},"doc_type":"ACCOUNT","owner.id":"o","owner.msp_id":"Org1MSP","status":"ACTIVE","updated_at":{"$gte":"2020-01-02T00:00:00.000000000Z"}}}`, query)
+
+	filter = AccountFilter{OwnerID: `x"},"doc_type":{"$ne":"ACCOUNT`}
+	query, err = buildAccountQuery(&filter)
+	assert.Nil(t, err)
+	assert.Contains(t, query, `"doc_type":"ACCOUNT"`, "injected value stays a string.")
+
+	_, err = buildAccountQuery(&AccountFilter{UpdatedSince: "yesterday"})
+	assert.NotNil(t, err)
+}
+
+func Test_JournalRange(t *testing.T) {
+	key := func(account string, timestamp string) string {
+		return journalKey(&JournalEntry{Account: account, Timestamp: timestamp, TxID: "tx", Seq: 1})
+	}
+	inRange := func(key string, start string, end string) bool {
+		return key >= start && key < end
+	}
+	from := "2020-01-01T00:00:00.000000000Z"
+	to := "2020-02-01T00:00:00.000000000Z"
+
+	start, end := journalRange("alice", from, to)
+	assert.True(t, inRange(key("alice", from), start, end), "from is included.")
+	assert.True(t, inRange(key("alice", "2020-01-31T23:59:59.999999999Z"), start, end))
+	assert.False(t, inRange(key("alice", to), start, end), "to is excluded.")
+	assert.False(t, inRange(key("alice", "2019-12-31T23:59:59.999999999Z"), start, end))
+
+	// open bounds cover the account only, not accounts sharing its name as prefix
+	start, end = journalRange("alice", "", "")
+	assert.True(t, inRange(key("alice", from), start, end))
+	assert.False(t, inRange(key("alice2", from), start, end))
+	assert.False(t, inRange(key("alic", from), start, end))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Reconciliation - proof that the balance of an account equals its opening balance plus the journaled
+// movements of every value recorded in key history, or the first transaction where the two diverge
+type Reconciliation struct {
+	Account     string  `json:"account"`
+	Asset       string  `json:"asset"`
+	Reconciled  bool    `json:"reconciled"`
+	Balance     Amount  `json:"balance"`
+	Held        Amount  `json:"held"`
+	Opening     Amount  `json:"opening"`
+	OpeningTx   string  `json:"opening_tx,omitempty"`
+	PreJournal  int     `json:"pre_journal"`
+	Transitions int     `json:"transitions"`
+	Entries     int     `json:"entries"`
+	FirstTx     string  `json:"first_tx,omitempty"`
+	LastTx      string  `json:"last_tx,omitempty"`
+	DivergedTx  string  `json:"diverged_tx,omitempty"`
+	Expected    *Amount `json:"expected,omitempty"`
+	Actual      *Amount `json:"actual,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// historyValue - balance of asset recorded by one transaction in key history of an account
+type historyValue struct {
+	TxID    string
+	Balance *Balance
+}
+
+// diverge - mark reconciliation failed at transaction
+func (t *Reconciliation) diverge(txID string, expected *Amount, actual *Amount, reason string) {
+	t.Reconciled = false
+	t.DivergedTx = txID
+	t.Expected = expected
+	t.Actual = actual
+	t.Reason = reason
+}
+
+// historyBalance - balance of asset in a recorded value of the account key, bare integers stored by
+// the first versions hold the default currency. A balance not opened yet counts as 0.
+func historyBalance(value []byte, assetCode string) (*Balance, error) {
+	if amount, ok := ParseLegacyBalance(value); ok {
+		if assetCode != DEFAULT_CURRENCY {
+			amount = ZeroAmount(0)
+		}
+		return &Balance{Amount: amount, Held: ZeroAmount(0)}, nil
+	}
+	account, err := ParseAccount(value)
+	if err != nil {
+		return nil, err
+	}
+	balance := account.GetBalance(assetCode)
+	if balance == nil {
+		return &Balance{Amount: ZeroAmount(0), Held: ZeroAmount(0)}, nil
+	}
+	return balance, nil
+}
+
+// accountHistory - balances of asset recorded for account, oldest first. Accounts were kept under
+// their bare name until the upgrade moved them to namespaced keys, so that history is walked first.
+// Deletions are skipped, the only one being the move of the bare key.
+func accountHistory(stub shim.ChaincodeStubInterface, accountName string, assetCode string) ([]*historyValue, error) {
+	key, err := accountKey(stub, accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*historyValue, 0)
+	for _, key := range []string{accountName, key} {
+		historyIt, err := stub.GetHistoryForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		for historyIt.HasNext() {
+			modification, err := historyIt.Next()
+			if err != nil {
+				historyIt.Close()
+				return nil, err
+			}
+			if modification.IsDelete {
+				continue
+			}
+			balance, err := historyBalance(modification.Value, assetCode)
+			if err != nil {
+				historyIt.Close()
+				return nil, fmt.Errorf(`invalid history value. (tx: "%s", cause: %s)`, modification.TxId, err)
+			}
+			values = append(values, &historyValue{TxID: modification.TxId, Balance: balance})
+		}
+		historyIt.Close()
+	}
+	return values, nil
+}
+
+// journalByTx - journal entries of account for asset grouped by transaction, in sequence order
+func journalByTx(stub shim.ChaincodeStubInterface, accountName string, assetCode string) (map[string][]*JournalEntry, error) {
+	startKey, endKey := journalRange(accountName, "", "")
+	resultIt, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultIt.Close()
+
+	entries := make(map[string][]*JournalEntry)
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry, err := ParseJournalEntry(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Asset != assetCode {
+			continue
+		}
+		entries[entry.TxID] = append(entries[entry.TxID], entry)
+	}
+	for _, list := range entries {
+		sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+	}
+	return entries, nil
+}
+
+// replayTransition - balance the journal entries of a transaction lead to from prev, and the reason
+// why they do not explain the recorded balance, empty if they do
+func replayTransition(prev Amount, next *Balance, entries []*JournalEntry) (Amount, string) {
+	expected := prev
+	for _, entry := range entries {
+		expected = expected.Add(entry.Amount)
+	}
+	if expected.Cmp(next.Amount) != 0 {
+		return expected, "journaled movements do not add up to the recorded balance"
+	}
+	if len(entries) == 0 {
+		return expected, ""
+	}
+	last := entries[len(entries)-1]
+	if last.Balance.Cmp(next.Amount) != 0 {
+		return last.Balance, "journaled balance differs from the recorded balance"
+	}
+	if last.Held != nil && last.Held.Cmp(next.Held) != 0 {
+		return *last.Held, "journaled held amount differs from the recorded held amount"
+	}
+	return expected, ""
+}
+
+// reconcile replays key history against the journal. Values written before the first journaled
+// transaction of the balance, such as imported or migrated ones, are folded into the opening balance;
+// every later value must be explained by the journal entries of its transaction, and journal entries
+// moving funds in a transaction which did not write the account diverge as well.
+func reconcile(history []*historyValue, entries map[string][]*JournalEntry, report *Reconciliation) {
+	report.Reconciled = true
+	first := len(history)
+	for idx, value := range history {
+		if len(entries[value.TxID]) > 0 {
+			first = idx
+			break
+		}
+	}
+	if len(history) > 0 {
+		report.FirstTx = history[0].TxID
+		report.LastTx = history[len(history)-1].TxID
+	}
+
+	prev := ZeroAmount(0)
+	if first > 0 {
+		report.OpeningTx = history[first-1].TxID
+		prev = history[first-1].Balance.Amount
+	}
+	report.Opening = prev
+	report.PreJournal = first
+
+	for _, value := range history[first:] {
+		txEntries := entries[value.TxID]
+		delete(entries, value.TxID)
+		expected, reason := replayTransition(prev, value.Balance, txEntries)
+		if reason != "" {
+			actual := value.Balance.Amount
+			report.diverge(value.TxID, &expected, &actual, reason)
+			return
+		}
+		report.Transitions++
+		report.Entries += len(txEntries)
+		prev = value.Balance.Amount
+	}
+
+	var orphan *JournalEntry
+	for _, list := range entries {
+		for _, entry := range list {
+			if entry.Amount.Sign() == 0 {
+				continue
+			}
+			if orphan == nil || entry.Timestamp < orphan.Timestamp || (entry.Timestamp == orphan.Timestamp && entry.TxID < orphan.TxID) {
+				orphan = entry
+			}
+		}
+	}
+	if orphan != nil {
+		amount := orphan.Amount
+		report.diverge(orphan.TxID, &amount, nil, "journaled movement without a recorded value of the account")
+	}
+}
+
+// reconcileAccount: query proof that the balance of account equals the sum of its journaled movements
+// (owner or admin only), <account> <asset>. Key history must be enabled on the peer.
+func (t *BalanceManager) reconcileAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2")
+	}
+
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	account, balance, resp := loadBalance(stub, args[0], asset)
+	if account == nil {
+		return resp
+	}
+	if !isAdmin(stub) {
+		err := authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+
+	history, err := accountHistory(stub, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	entries, err := journalByTx(stub, account.Name, asset.Code)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	report := Reconciliation{Account: account.Name, Asset: asset.Code, Balance: balance.Amount, Held: balance.Held}
+	reconcile(history, entries, &report)
+	// bare integer strings stored before account documents carry integer amounts
+	if opening, err := report.Opening.Rescale(asset.Decimals); err == nil {
+		report.Opening = opening
+	}
+
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
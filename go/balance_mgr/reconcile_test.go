@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reconcile(t *testing.T) {
+	amount := func(val string) Amount {
+		a, _ := ParseAmount(val, 2)
+		return a
+	}
+	balance := func(val string) *Balance {
+		return &Balance{Amount: amount(val), Held: amount("0")}
+	}
+	entry := func(txID string, seq int, delta string, total string) *JournalEntry {
+		return &JournalEntry{TxID: txID, Seq: seq, Asset: "PTS", Amount: amount(delta), Balance: amount(total)}
+	}
+
+	// imported value is the opening, later values are proven by the journal
+	history := []*historyValue{
+		{TxID: "tx0", Balance: balance("50")},
+		{TxID: "tx1", Balance: balance("80")},
+		{TxID: "tx2", Balance: balance("69")},
+	}
+	entries := map[string][]*JournalEntry{
+		"tx1": {entry("tx1", 0, "30", "80")},
+		"tx2": {entry("tx2", 0, "-10", "70"), entry("tx2", 1, "-1", "69")},
+	}
+	report := Reconciliation{}
+	reconcile(history, entries, &report)
+	assert.True(t, report.Reconciled)
+	assert.Equal(t, "tx0", report.OpeningTx)
+	assert.Equal(t, "50.00", report.Opening.String())
+	assert.Equal(t, 1, report.PreJournal)
+	assert.Equal(t, 2, report.Transitions)
+	assert.Equal(t, 3, report.Entries)
+
+	// a value changed without matching movements
+	history = append(history, &historyValue{TxID: "tx3", Balance: balance("100")})
+	entries = map[string][]*JournalEntry{
+		"tx1": {entry("tx1", 0, "30", "80")},
+		"tx2": {entry("tx2", 0, "-10", "70"), entry("tx2", 1, "-1", "69")},
+		"tx3": {entry("tx3", 0, "1", "70")},
+	}
+	report = Reconciliation{}
+	reconcile(history, entries, &report)
+	assert.False(t, report.Reconciled)
+	assert.Equal(t, "tx3", report.DivergedTx)
+	assert.Equal(t, "70.00", report.Expected.String())
+	assert.Equal(t, "100.00", report.Actual.String())
+
+	// a movement journaled by a transaction which did not write the account
+	entries = map[string][]*JournalEntry{
+		"tx1": {entry("tx1", 0, "30", "80")},
+		"tx2": {entry("tx2", 0, "-10", "70"), entry("tx2", 1, "-1", "69")},
+		"tx9": {entry("tx9", 0, "5", "74")},
+	}
+	report = Reconciliation{}
+	reconcile(history[:3], entries, &report)
+	assert.False(t, report.Reconciled)
+	assert.Equal(t, "tx9", report.DivergedTx)
+	assert.Nil(t, report.Actual)
+}
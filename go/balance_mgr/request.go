@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_REQUEST = "creator~request"
+	INDEX_CONFIG  = "config"
+
+	// CONFIG_REQUEST_WINDOW - config entry of the time a request id is remembered
+	CONFIG_REQUEST_WINDOW = "request_window"
+	// DEFAULT_REQUEST_WINDOW - time a request id is remembered unless configured
+	DEFAULT_REQUEST_WINDOW = 24 * time.Hour
+
+	// TRANSIENT_REQUEST_ID - transient field of the client request id, kept out of the invoke arguments
+	// so that every state-changing invoke accepts it
+	TRANSIENT_REQUEST_ID = "request_id"
+)
+
+// IDEMPOTENT_FUNCTIONS - state-changing invokes deduplicated by client request id
+var IDEMPOTENT_FUNCTIONS = map[string]bool{
+	"create": true, "charge": true, "mint": true, "burn": true,
+	"transfer": true, "transferFrom": true, "approve": true, "revoke": true,
+	"batchTransfer": true, "exchange": true, "registerAsset": true,
+	"hold": true, "release": true, "cancelHold": true, "setFeeSchedule": true,
+	"freeze": true, "unfreeze": true, "close": true, "setCreditLimit": true, "assignOwner": true,
+	"put": true, "setMetadata": true, "rotateMetadata": true,
+	"shield": true, "unshield": true, "transferPrivate": true, "claimPrivate": true,
+	"addAccountOrgs": true, "removeAccountOrgs": true, "setRequestWindow": true,
+	"schedule": true, "cancelSchedule": true, "executeDue": true,
+	"import": true, "setAlias": true, "removeAlias": true, "transferAlias": true,
+	"setInterestRate": true, "setAccountInterestRate": true, "accrue": true, "accrueAll": true,
+	"setSpendingLimit": true, "setAccountClass": true, "recordLimitBreach": true,
+	"setSignerPolicy": true, "proposeTransfer": true, "approveTransfer": true, "rejectTransfer": true,
+	"setRoles": true,
+}
+
+// Request - client request id processed by a committed transaction of the creator, stored under
+// composite key 'creator~request' and remembered until expiry
+type Request struct {
+	AbstractDoc
+	ID        string   `json:"id"`
+	Creator   Identity `json:"creator"`
+	Function  string   `json:"function"`
+	TxID      string   `json:"tx_id"`
+	CreatedAt string   `json:"created_at"`
+	Expiry    string   `json:"expiry"`
+}
+
+// RequestWindow - config of the time a request id is remembered
+type RequestWindow struct {
+	AbstractDoc
+	Window    string `json:"window"`
+	UpdatedTx string `json:"updated_tx"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// ParseRequest - parse request document
+func ParseRequest(data []byte) (*Request, error) {
+	request := Request{}
+	err := json.Unmarshal(data, &request)
+	if err != nil || request.DocType != DOC_REQUEST {
+		return nil, fmt.Errorf(`invalid request document. (value: "%s")`, string(data))
+	}
+	if request.Version > REQUEST_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported request document version. (expecting <= %d, actual: %d)`, REQUEST_DOC_VERSION, request.Version)
+	}
+	return &request, nil
+}
+
+// expired - check whether request id is forgotten at time
+func (t *Request) expired(now time.Time) bool {
+	return t.Expiry <= now.UTC().Format(TIMESTAMP_FORMAT)
+}
+
+func requestKey(stub shim.ChaincodeStubInterface, creator Identity, requestID string) (string, error) {
+	return stub.CreateCompositeKey(INDEX_REQUEST, []string{creator.MSPID, creator.ID, requestID})
+}
+
+// requestRegistry - processed request ids, kept in world state and replaced in tests
+type requestRegistry interface {
+	getRequest(creator Identity, requestID string) (*Request, error)
+	putRequest(request *Request) error
+}
+
+// ledgerRegistry - request registry under composite key 'creator~request'
+type ledgerRegistry struct {
+	stub shim.ChaincodeStubInterface
+}
+
+// getRequest - load request of creator, nil if never processed
+func (t ledgerRegistry) getRequest(creator Identity, requestID string) (*Request, error) {
+	key, err := requestKey(t.stub, creator, requestID)
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := t.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseRequest(valBytes)
+}
+
+// putRequest - record request of creator
+func (t ledgerRegistry) putRequest(request *Request) error {
+	key, err := requestKey(t.stub, request.Creator, request.ID)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return t.stub.PutState(key, bytes)
+}
+
+// getRequest - load request of creator, nil if never processed
+func getRequest(stub shim.ChaincodeStubInterface, creator Identity, requestID string) (*Request, error) {
+	return ledgerRegistry{stub: stub}.getRequest(creator, requestID)
+}
+
+// requestWindow - configured time a request id is remembered
+func requestWindow(stub shim.ChaincodeStubInterface) (time.Duration, error) {
+	key, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_REQUEST_WINDOW})
+	if err != nil {
+		return 0, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(valBytes) == 0 {
+		return DEFAULT_REQUEST_WINDOW, nil
+	}
+	config := RequestWindow{}
+	err = json.Unmarshal(valBytes, &config)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid request window config. (value: "%s")`, string(valBytes))
+	}
+	return time.ParseDuration(config.Window)
+}
+
+// duplicateRequest - error response of a replayed request, carrying the tx id which processed it
+func duplicateRequest(request *Request) pb.Response {
+	payload := ErrorPayload{
+		Code:    ERR_DUPLICATE_REQUEST,
+		Message: fmt.Sprintf(`Request already processed. (request: "%s", function: "%s", tx: "%s")`, request.ID, request.Function, request.TxID),
+		TxID:    request.TxID,
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return shim.Error(payload.Message)
+	}
+	return shim.Error(string(bytes))
+}
+
+// invokeOnce - dispatch invoke unless the client request id given in the transient map was processed
+// within the request window. The id is recorded only if the invoke succeeds, concurrent submissions
+// of one id are left to the MVCC check of the registry key.
+func (t *BalanceManager) invokeOnce(stub shim.ChaincodeStubInterface, funcName string, args []string) pb.Response {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	requestID := string(transient[TRANSIENT_REQUEST_ID])
+	if requestID == "" {
+		return t.dispatch(stub, funcName, args)
+	}
+
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	window, err := requestWindow(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	request := &Request{ID: requestID, Creator: creator, Function: funcName, TxID: stub.GetTxID()}
+	return processOnce(ledgerRegistry{stub: stub}, request, now, window, func() pb.Response {
+		return t.dispatch(stub, funcName, args)
+	})
+}
+
+// processOnce - call dispatch unless request id of creator is recorded and not expired, in which case
+// the replay is answered with the tx id which processed it. The request is recorded with expiry at
+// now plus window only if dispatch succeeds, so that a failed invoke can be retried with the same id.
+func processOnce(registry requestRegistry, request *Request, now time.Time, window time.Duration, dispatch func() pb.Response) pb.Response {
+	processed, err := registry.getRequest(request.Creator, request.ID)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if processed != nil && !processed.expired(now) {
+		return duplicateRequest(processed)
+	}
+
+	resp := dispatch()
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	request.CreatedAt = now.Format(TIMESTAMP_FORMAT)
+	request.Expiry = now.Add(window).Format(TIMESTAMP_FORMAT)
+	request.DocType = DOC_REQUEST
+	request.Version = REQUEST_DOC_VERSION
+	err = registry.putRequest(request)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	return resp
+}
+
+// queryRequest: query outcome of a request id of the creator. Not found means no transaction
+// processing it was committed within the request window, so it is safe to resubmit.
+func (t *BalanceManager) queryRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	creator, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf("Failed to resolve creator identity. cause: (%s)", err))
+	}
+	request, err := getRequest(stub, creator, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if request == nil {
+		return errorResponse(ERR_REQUEST_NOT_FOUND, fmt.Sprintf(`Request not found. (request: "%s")`, args[0]))
+	}
+
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// setRequestWindow: set the time request ids are remembered (admin only), e.g. '24h'.
+// Requests already processed keep their expiry.
+func (t *BalanceManager) setRequestWindow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to set request window.")
+	}
+
+	window, err := time.ParseDuration(args[0])
+	if err != nil || window <= 0 {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid request window, expecting a positive duration such as "24h". (actual: "%s")`, args[0]))
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	config := RequestWindow{Window: window.String(), UpdatedTx: stub.GetTxID(), UpdatedAt: timestamp}
+	config.DocType = DOC_CONFIG
+	config.Version = CONFIG_DOC_VERSION
+	key, err := stub.CreateCompositeKey(INDEX_CONFIG, []string{CONFIG_REQUEST_WINDOW})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_REQUEST_WINDOW_SET, RequestWindowEvent{Window: config.Window})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// purgeRequests: remove expired request ids of every creator (admin only), at most limit of them.
+// The number of removed ids is returned.
+func (t *BalanceManager) purgeRequests(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to purge requests.")
+	}
+
+	limit, err := parsePageSize(args[0])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_REQUEST, []string{})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
+
+	purged := 0
+	for resultIt.HasNext() && purged < int(limit) {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		request, err := ParseRequest(kv.Value)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if !request.expired(now) {
+			continue
+		}
+		err = stub.DelState(kv.Key)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		purged++
+	}
+
+	err = emitEvent(stub, EVENT_REQUESTS_PURGED, RequestsPurgedEvent{Purged: purged})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success([]byte(strconv.Itoa(purged)))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseRequest(t *testing.T) {
+	data := `{"doc_type":"REQUEST","version":1,"id":"r1","creator":{"id":"u1","msp_id":"Org1MSP"},"function":"charge","tx_id":"tx1","created_at":"2020-01-01T00:00:00.000000000Z","expiry":"2020-01-02T00:00:00.000000000Z"}`
+	request, err := ParseRequest([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "tx1", request.TxID)
+
+	assert.False(t, request.expired(time.Date(2020, 1, 1, 23, 59, 59, 0, time.UTC)))
+	assert.True(t, request.expired(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+
+	// a replay is rejected with the tx id which processed the request
+	resp := duplicateRequest(request)
+	payload := ErrorPayload{}
+	assert.Nil(t, json.Unmarshal([]byte(resp.Message), &payload))
+	assert.Equal(t, ERR_DUPLICATE_REQUEST, payload.Code)
+	assert.Equal(t, "tx1", payload.TxID)
+
+	_, err = ParseRequest([]byte(`{"doc_type":"HOLD","version":1}`))
+	assert.NotNil(t, err)
+}
+
+// memoryRegistry - request registry kept in memory
+type memoryRegistry map[string]*Request
+
+func (t memoryRegistry) getRequest(creator Identity, requestID string) (*Request, error) {
+	return t[creator.MSPID+"/"+creator.ID+"/"+requestID], nil
+}
+
+func (t memoryRegistry) putRequest(request *Request) error {
+	t[request.Creator.MSPID+"/"+request.Creator.ID+"/"+request.ID] = request
+	return nil
+}
+
+func Test_ProcessOnce(t *testing.T) {
+	registry := memoryRegistry{}
+	creator := Identity{ID: "u1", MSPID: "Org1MSP"}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	succeed := func() pb.Response {
+		calls++
+		return shim.Success([]byte("done"))
+	}
+	fail := func() pb.Response {
+		calls++
+		return errorResponse(ERR_INSUFFICIENT_FUNDS, "Insufficient funds.")
+	}
+
+	// a failed invoke leaves the id free for a retry
+	resp := processOnce(registry, &Request{ID: "r1", Creator: creator, TxID: "tx1"}, now, time.Hour, fail)
+	assert.Equal(t, int32(shim.ERROR), resp.Status)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 0, len(registry))
+
+	resp = processOnce(registry, &Request{ID: "r1", Creator: creator, TxID: "tx2"}, now, time.Hour, succeed)
+	assert.Equal(t, int32(shim.OK), resp.Status)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, len(registry))
+
+	// a replay within the window is answered without dispatch
+	resp = processOnce(registry, &Request{ID: "r1", Creator: creator, TxID: "tx3"}, now.Add(59*time.Minute), time.Hour, succeed)
+	assert.Equal(t, 2, calls)
+	payload := ErrorPayload{}
+	assert.Nil(t, json.Unmarshal([]byte(resp.Message), &payload))
+	assert.Equal(t, ERR_DUPLICATE_REQUEST, payload.Code)
+	assert.Equal(t, "tx2", payload.TxID)
+
+	// the same id of another creator is a different request
+	other := Identity{ID: "u1", MSPID: "Org2MSP"}
+	resp = processOnce(registry, &Request{ID: "r1", Creator: other, TxID: "tx4"}, now, time.Hour, succeed)
+	assert.Equal(t, int32(shim.OK), resp.Status)
+	assert.Equal(t, 3, calls)
+
+	// once expired, the id is admitted again and recorded with the new tx
+	resp = processOnce(registry, &Request{ID: "r1", Creator: creator, TxID: "tx5"}, now.Add(time.Hour), time.Hour, succeed)
+	assert.Equal(t, int32(shim.OK), resp.Status)
+	assert.Equal(t, 4, calls)
+	request, _ := registry.getRequest(creator, "r1")
+	assert.Equal(t, "tx5", request.TxID)
+	assert.Equal(t, "2020-01-01T02:00:00.000000000Z", request.Expiry)
+}
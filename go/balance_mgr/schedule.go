@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	INDEX_ORDER     = "order"
+	INDEX_ORDER_DUE = "due~order"
+)
+
+type OrderStatus string
+
+const (
+	ORDER_ACTIVE    OrderStatus = "ACTIVE"
+	ORDER_COMPLETED OrderStatus = "COMPLETED"
+	ORDER_CANCELLED OrderStatus = "CANCELLED"
+)
+
+type ExecutionStatus string
+
+const (
+	EXECUTION_DONE   ExecutionStatus = "EXECUTED"
+	EXECUTION_FAILED ExecutionStatus = "FAILED"
+)
+
+// StandingOrder - transfer repeated every interval from next due time until the optional end,
+// identified by the tx id which scheduled it. Active orders are also indexed by 'due~order'.
+type StandingOrder struct {
+	AbstractDoc
+	ID         string      `json:"id"`
+	Owner      Identity    `json:"owner"`
+	From       string      `json:"from"`
+	To         string      `json:"to"`
+	Asset      string      `json:"asset"`
+	Amount     Amount      `json:"amount"`
+	Interval   string      `json:"interval"`
+	NextDue    string      `json:"next_due"`
+	End        string      `json:"end,omitempty"`
+	Memo       string      `json:"memo,omitempty"`
+	Status     OrderStatus `json:"status"`
+	Executions int         `json:"executions"`
+	Failures   int         `json:"failures"`
+	LastTx     string      `json:"last_tx,omitempty"`
+	LastError  string      `json:"last_error,omitempty"`
+	CreatedAt  string      `json:"created_at"`
+	UpdatedTx  string      `json:"updated_tx"`
+	UpdatedAt  string      `json:"updated_at"`
+}
+
+// OrderExecution - outcome of a standing order processed by executeDue
+type OrderExecution struct {
+	Order  string          `json:"order"`
+	Due    string          `json:"due"`
+	Status ExecutionStatus `json:"status"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// ParseStandingOrder - parse standing order document
+func ParseStandingOrder(data []byte) (*StandingOrder, error) {
+	order := StandingOrder{}
+	err := json.Unmarshal(data, &order)
+	if err != nil || order.DocType != DOC_ORDER {
+		return nil, fmt.Errorf(`invalid standing order document. (value: "%s")`, string(data))
+	}
+	if order.Version > ORDER_DOC_VERSION {
+		return nil, fmt.Errorf(`unsupported standing order document version. (expecting <= %d, actual: %d)`, ORDER_DOC_VERSION, order.Version)
+	}
+	return &order, nil
+}
+
+// addInterval - time one interval after tm. Calendar intervals are given in days 'd', weeks 'w' or
+// months 'M', such as '1M', other intervals as a duration such as '12h'.
+func addInterval(tm time.Time, interval string) (time.Time, error) {
+	if len(interval) > 1 && strings.ContainsAny(interval[len(interval)-1:], "dwM") {
+		count, err := strconv.Atoi(interval[:len(interval)-1])
+		if err != nil || count <= 0 {
+			return time.Time{}, fmt.Errorf(`Invalid interval, expecting a positive count of days 'd', weeks 'w' or months 'M'. (actual: "%s")`, interval)
+		}
+		switch interval[len(interval)-1] {
+		case 'd':
+			return tm.AddDate(0, 0, count), nil
+		case 'w':
+			return tm.AddDate(0, 0, 7*count), nil
+		default:
+			return tm.AddDate(0, count, 0), nil
+		}
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil || duration <= 0 {
+		return time.Time{}, fmt.Errorf(`Invalid interval, expecting a positive duration such as "12h" or a calendar interval such as "1M". (actual: "%s")`, interval)
+	}
+	return tm.Add(duration), nil
+}
+
+// getOrder - load standing order, nil if not existing
+func getOrder(stub shim.ChaincodeStubInterface, orderID string) (*StandingOrder, error) {
+	key, err := stub.CreateCompositeKey(INDEX_ORDER, []string{orderID})
+	if err != nil {
+		return nil, err
+	}
+	valBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(valBytes) == 0 {
+		return nil, nil
+	}
+	return ParseStandingOrder(valBytes)
+}
+
+// putOrder - stamp standing order with current transaction and write it with its due index,
+// previousDue is the indexed due time to replace, empty for a new order
+func putOrder(stub shim.ChaincodeStubInterface, order *StandingOrder, previousDue string) error {
+	if previousDue != "" {
+		dueKey, err := stub.CreateCompositeKey(INDEX_ORDER_DUE, []string{previousDue, order.ID})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(dueKey)
+		if err != nil {
+			return err
+		}
+	}
+	if order.Status == ORDER_ACTIVE {
+		dueKey, err := stub.CreateCompositeKey(INDEX_ORDER_DUE, []string{order.NextDue, order.ID})
+		if err != nil {
+			return err
+		}
+		// index entries carry no value, but an empty value would delete the key
+		err = stub.PutState(dueKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	order.UpdatedTx = stub.GetTxID()
+	order.UpdatedAt = timestamp
+	key, err := stub.CreateCompositeKey(INDEX_ORDER, []string{order.ID})
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, bytes)
+}
+
+// schedule: persist a standing order transferring amount from account (owner only) every interval from
+// start until the optional end, <from> <to> <asset> <amount> <interval> <start> [end] [memo].
+// The tx id is returned as id of the order.
+func (t *BalanceManager) schedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("schedule standing order")
+
+	if len(args) < 6 || len(args) > 8 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 6 to 8")
+	}
+
+	accountFrom := args[0]
+	accountTo := args[1]
+	if accountFrom == accountTo {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Transfer to the same account is not allowed. (Account: "%s")`, accountFrom))
+	}
+
+	asset, resp := loadAsset(stub, args[2])
+	if asset == nil {
+		return resp
+	}
+	from, _, resp := loadBalance(stub, accountFrom, asset)
+	if from == nil {
+		return resp
+	}
+	err := authorizeOwner(stub, from)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, err.Error())
+	}
+	to, _, resp := loadBalance(stub, accountTo, asset)
+	if to == nil {
+		return resp
+	}
+	if resp, inactive := notActive(from); inactive {
+		return resp
+	}
+	if resp, inactive := notActive(to); inactive {
+		return resp
+	}
+
+	amount, err := parseAmount(args[3], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	resp = requireApproval(stub, accountFrom, asset, amount)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	start, err := time.Parse(time.RFC3339Nano, args[5])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid start, expecting RFC3339 format. (actual: "%s")`, args[5]))
+	}
+	start = start.UTC()
+	_, err = addInterval(start, args[4])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	end := ""
+	if val := optionalArg(args, 6); val != "" {
+		tm, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil || tm.Before(start) {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid end, expecting a time not before start in RFC3339 format. (actual: "%s")`, val))
+		}
+		end = tm.UTC().Format(TIMESTAMP_FORMAT)
+	}
+
+	owner, err := creatorIdentity(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	order := StandingOrder{
+		ID:        stub.GetTxID(),
+		Owner:     owner,
+		From:      accountFrom,
+		To:        accountTo,
+		Asset:     asset.Code,
+		Amount:    amount,
+		Interval:  args[4],
+		NextDue:   start.Format(TIMESTAMP_FORMAT),
+		End:       end,
+		Memo:      optionalArg(args, 7),
+		Status:    ORDER_ACTIVE,
+		CreatedAt: timestamp,
+	}
+	order.DocType = DOC_ORDER
+	order.Version = ORDER_DOC_VERSION
+	err = putOrder(stub, &order, "")
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ORDER_SCHEDULED, OrderEvent{Order: &order})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success([]byte(order.ID))
+}
+
+// cancelSchedule: cancel active standing order (its owner or admin), <orderID> [reason]
+func (t *BalanceManager) cancelSchedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("cancel standing order")
+
+	if len(args) != 1 && len(args) != 2 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if order == nil {
+		return errorResponse(ERR_ORDER_NOT_FOUND, fmt.Sprintf(`Standing order not found. (order: "%s")`, args[0]))
+	}
+	if !isAdmin(stub) {
+		creator, err := creatorIdentity(stub)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if !order.Owner.Equals(creator) {
+			return errorResponse(ERR_ACCESS_DENIED, fmt.Sprintf(`creator is not the owner of standing order. (order: "%s", creator: "%s" of "%s")`, order.ID, creator.ID, creator.MSPID))
+		}
+	}
+	if order.Status != ORDER_ACTIVE {
+		return errorResponse(ERR_INVALID_STATUS, fmt.Sprintf(`Standing order is not active. (order: "%s", status: "%s")`, order.ID, order.Status))
+	}
+
+	reason := optionalArg(args, 1)
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp := cancelOrder(stub, journal, newAccountCache(stub), order, reason)
+	if resp.Status != shim.OK {
+		return resp
+	}
+
+	err = emitEvent(stub, EVENT_ORDER_CANCELLED, OrderEvent{Order: order, Reason: reason})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// cancelOrder - stop standing order and journal the cancellation on the paying account
+func cancelOrder(stub shim.ChaincodeStubInterface, journal *Journal, cache *accountCache, order *StandingOrder, reason string) pb.Response {
+	previousDue := order.NextDue
+	order.Status = ORDER_CANCELLED
+	err := putOrder(stub, order, previousDue)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = journal.AppendRef(ENTRY_ORDER_CANCEL, order.From, order.Asset, order.To, ZeroAmount(order.Amount.Scale()), orderBalance(cache, order), order.ID, reason)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// orderBalance - current balance of the paying account of order, zero if unavailable
+func orderBalance(cache *accountCache, order *StandingOrder) Amount {
+	account, err := cache.getAccount(order.From)
+	if err == nil && account != nil {
+		if balance := account.GetBalance(order.Asset); balance != nil {
+			return balance.Amount
+		}
+	}
+	return ZeroAmount(order.Amount.Scale())
+}
+
+// executeDue: process standing orders due before the transaction timestamp, callable by any keeper.
+// Each order is executed once per call, at most limit of them, so orders missing several intervals
+// catch up on later calls. Failures are journaled and the order moves on to its next interval.
+func (t *BalanceManager) executeDue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("execute due standing orders")
+
+	if len(args) > 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 0 or 1")
+	}
+	limit := int32(MAX_PAGE_SIZE)
+	if len(args) == 1 {
+		var err error
+		limit, err = parsePageSize(args[0])
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	nowStamp := now.Format(TIMESTAMP_FORMAT)
+
+	// due times are fixed width, so the index is ordered by due time
+	resultIt, err := stub.GetStateByPartialCompositeKey(INDEX_ORDER_DUE, []string{})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	orderIDs := make([]string, 0)
+	for resultIt.HasNext() && len(orderIDs) < int(limit) {
+		kv, err := resultIt.Next()
+		if err != nil {
+			resultIt.Close()
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		_, keys, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			resultIt.Close()
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if keys[0] >= nowStamp {
+			break
+		}
+		orderIDs = append(orderIDs, keys[1])
+	}
+	resultIt.Close()
+
+	cache := newAccountCache(stub)
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	executions := make([]*OrderExecution, 0, len(orderIDs))
+	for _, orderID := range orderIDs {
+		order, err := getOrder(stub, orderID)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if order == nil {
+			return errorResponse(ERR_LEDGER, fmt.Sprintf(`Standing order of due index not found. (order: "%s")`, orderID))
+		}
+		execution, resp := executeOrder(stub, cache, journal, order)
+		if execution == nil {
+			return resp
+		}
+		executions = append(executions, execution)
+	}
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_ORDERS_EXECUTED, OrderExecutionEvent{Executions: executions})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	bytes, err := json.Marshal(executions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// executeOrder - transfer one interval of order and move it to the next due time. A failed transfer
+// is recorded on the order, the error response is only returned if the ledger fails.
+func executeOrder(stub shim.ChaincodeStubInterface, cache *accountCache, journal *Journal, order *StandingOrder) (*OrderExecution, pb.Response) {
+	execution := OrderExecution{Order: order.ID, Due: order.NextDue, Status: EXECUTION_DONE}
+
+	asset, err := cache.getAsset(order.Asset)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	from, err := cache.getAccount(order.From)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	var failure *ErrorPayload
+	if asset == nil || from == nil {
+		failure = &ErrorPayload{Code: ERR_ACCOUNT_NOT_FOUND, Message: fmt.Sprintf(`Paying account or asset of standing order not found. (Account: "%s", asset: "%s")`, order.From, order.Asset)}
+	} else if !from.Owner.Equals(order.Owner) {
+		// the order was authorized by a previous owner of the paying account
+		failure = &ErrorPayload{Code: ERR_ACCESS_DENIED, Message: fmt.Sprintf(`Owner of paying account changed. (Account: "%s")`, order.From)}
+	} else {
+		resp := requireApproval(stub, order.From, asset, order.Amount)
+		if resp.Status == shim.OK {
+			_, resp = moveFunds(stub, cache, journal, order.From, order.To, asset, order.Amount, order.ID, order.Memo)
+		}
+		if resp.Status != shim.OK {
+			payload := parseErrorPayload(resp)
+			if payload.Code == ERR_LEDGER {
+				return nil, resp
+			}
+			failure = &payload
+		}
+	}
+
+	if failure != nil {
+		execution.Status = EXECUTION_FAILED
+		execution.Error = failure.Message
+		order.Failures++
+		order.LastError = failure.Message
+		err = journal.AppendRef(ENTRY_ORDER_FAILED, order.From, order.Asset, order.To, ZeroAmount(order.Amount.Scale()), orderBalance(cache, order), order.ID, failure.Message)
+		if err != nil {
+			return nil, errorResponse(ERR_LEDGER, err.Error())
+		}
+		// the order can never succeed again
+		if failure.Code == ERR_ACCOUNT_CLOSED || failure.Code == ERR_ACCESS_DENIED || failure.Code == ERR_ACCOUNT_NOT_FOUND {
+			resp := cancelOrder(stub, journal, cache, order, failure.Message)
+			if resp.Status != shim.OK {
+				return nil, resp
+			}
+			return &execution, shim.Success(nil)
+		}
+	} else {
+		order.Executions++
+		order.LastTx = stub.GetTxID()
+		order.LastError = ""
+	}
+
+	previousDue := order.NextDue
+	due, _ := time.Parse(TIMESTAMP_FORMAT, order.NextDue)
+	next, err := addInterval(due, order.Interval)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	order.NextDue = next.UTC().Format(TIMESTAMP_FORMAT)
+	if order.End != "" && order.NextDue > order.End {
+		order.Status = ORDER_COMPLETED
+	}
+	err = putOrder(stub, order, previousDue)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	return &execution, shim.Success(nil)
+}
+
+// queryOrder: query standing order
+func (t *BalanceManager) queryOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	if order == nil {
+		return errorResponse(ERR_ORDER_NOT_FOUND, fmt.Sprintf(`Standing order not found. (order: "%s")`, args[0]))
+	}
+
+	bytes, err := json.Marshal(order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
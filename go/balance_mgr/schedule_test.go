@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AddInterval(t *testing.T) {
+	start := time.Date(2020, 1, 15, 8, 0, 0, 0, time.UTC)
+
+	next, err := addInterval(start, "1M")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 2, 15, 8, 0, 0, 0, time.UTC), next)
+
+	next, err = addInterval(start, "2w")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 1, 29, 8, 0, 0, 0, time.UTC), next)
+
+	next, err = addInterval(start, "10d")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 1, 25, 8, 0, 0, 0, time.UTC), next)
+
+	next, err = addInterval(start, "90m")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2020, 1, 15, 9, 30, 0, 0, time.UTC), next)
+
+	for _, interval := range []string{"", "0d", "-1M", "xM", "0s", "-1h", "monthly"} {
+		_, err = addInterval(start, interval)
+		assert.NotNil(t, err, interval)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// EXPORT_ASSETS, EXPORT_ACCOUNTS - sections of export, assets with their supply must be imported first
+	EXPORT_ASSETS   = "assets"
+	EXPORT_ACCOUNTS = "accounts"
+
+	// IMPORT_FINAL - flag of the last import page, which checks the supply invariant
+	IMPORT_FINAL = "final"
+)
+
+// ExportPage - page of exported documents as JSON lines, the checksum is the hex SHA-256 of data
+type ExportPage struct {
+	Section  string `json:"section"`
+	Count    int    `json:"count"`
+	Bookmark string `json:"bookmark"`
+	Checksum string `json:"checksum"`
+	Data     string `json:"data"`
+}
+
+// importBatch - documents loaded by one import page, which later reads of the same transaction do not see
+type importBatch struct {
+	assets   map[string]*Asset
+	supplies map[string]*Supply
+	accounts map[string]*Account
+	aliases  map[string]string
+}
+
+// exportChecksum - hex SHA-256 of page data
+func exportChecksum(data string) string {
+	digest := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(digest[:])
+}
+
+// export: export a page of a section, 'assets' or 'accounts', as JSON lines (admin only),
+// <section> <pageSize> <bookmark>. Confidential balances are not exported.
+func (t *BalanceManager) export(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to export.")
+	}
+
+	section := args[0]
+	pageSize, err := parsePageSize(args[1])
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+	}
+	bookmark := args[2]
+
+	var resultIt shim.StateQueryIteratorInterface
+	var metadata *pb.QueryResponseMetadata
+	switch section {
+	case EXPORT_ASSETS:
+		resultIt, metadata, err = stub.GetStateByPartialCompositeKeyWithPagination(INDEX_ASSET, []string{}, pageSize, bookmark)
+	case EXPORT_ACCOUNTS:
+		resultIt, metadata, err = stub.GetStateByPartialCompositeKeyWithPagination(INDEX_ACCOUNT, []string{}, pageSize, bookmark)
+	default:
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid section, expecting "%s" or "%s". (actual: "%s")`, EXPORT_ASSETS, EXPORT_ACCOUNTS, section))
+	}
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	defer resultIt.Close()
+
+	lines := make([]string, 0)
+	for resultIt.HasNext() {
+		kv, err := resultIt.Next()
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		var docs []interface{}
+		if section == EXPORT_ASSETS {
+			asset, err := ParseAsset(kv.Value)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			supply, resp := loadSupply(stub, asset)
+			if supply == nil {
+				return resp
+			}
+			docs = []interface{}{asset, supply}
+		} else {
+			// older layouts are exported converted to the current one
+			account, err := ParseAccount(kv.Value)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			docs = []interface{}{account}
+		}
+		for _, doc := range docs {
+			bytes, err := json.Marshal(doc)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			lines = append(lines, string(bytes))
+		}
+	}
+
+	data := strings.Join(lines, "\n")
+	page := ExportPage{Section: section, Count: len(lines), Bookmark: metadata.Bookmark, Checksum: exportChecksum(data), Data: data}
+	bytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// importPage: load a page of export into a fresh channel (admin only), <data> <checksum> ['final'].
+// Documents already existing are refused. The final page checks that the balances of every asset
+// add up to its circulating supply.
+func (t *BalanceManager) importPage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("import page of export")
+
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to import.")
+	}
+
+	data := args[0]
+	if exportChecksum(data) != strings.ToLower(args[1]) {
+		return errorResponse(ERR_INVALID_CHECKSUM, fmt.Sprintf(`Checksum of page does not match. (expecting: "%s", actual: "%s")`, args[1], exportChecksum(data)))
+	}
+	final := false
+	if val := optionalArg(args, 2); val != "" {
+		if val != IMPORT_FINAL {
+			return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid flag, expecting "%s". (actual: "%s")`, IMPORT_FINAL, val))
+		}
+		final = true
+	}
+
+	batch := importBatch{assets: make(map[string]*Asset), supplies: make(map[string]*Supply), accounts: make(map[string]*Account), aliases: make(map[string]string)}
+	for idx, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		resp := batch.load(stub, []byte(line))
+		if resp.Status != shim.OK {
+			payload := parseErrorPayload(resp)
+			return errorResponse(payload.Code, fmt.Sprintf("%s (line: %d)", payload.Message, idx+1))
+		}
+	}
+
+	if final {
+		resp := batch.checkSupply(stub)
+		if resp.Status != shim.OK {
+			return resp
+		}
+	}
+
+	err := emitEvent(stub, EVENT_IMPORTED, ImportEvent{Assets: len(batch.assets), Supplies: len(batch.supplies), Accounts: len(batch.accounts), Final: final})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// load - write one exported document as it was, refusing duplicates
+func (t *importBatch) load(stub shim.ChaincodeStubInterface, line []byte) pb.Response {
+	doc := AbstractDoc{}
+	err := json.Unmarshal(line, &doc)
+	if err != nil {
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf("Invalid line, expecting a JSON document. cause: (%s)", err))
+	}
+
+	var key string
+	var value interface{}
+	switch doc.DocType {
+	case DOC_ASSET:
+		asset, err := ParseAsset(line)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+		existing, err := getAsset(stub, asset.Code)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if existing != nil || t.assets[asset.Code] != nil {
+			return errorResponse(ERR_ASSET_EXISTS, fmt.Sprintf(`Asset already registered. (Asset: "%s")`, asset.Code))
+		}
+		key, err = stub.CreateCompositeKey(INDEX_ASSET, []string{asset.Code})
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		t.assets[asset.Code] = asset
+		value = asset
+	case DOC_SUPPLY:
+		supply, err := ParseSupply(line)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+		// confidential balances stay in the collections of the exporting channel
+		if supply.ShieldedAmount().Sign() != 0 {
+			return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Confidential balances must be unshielded before export. (Asset: "%s", shielded: %s)`, supply.Asset, supply.ShieldedAmount()))
+		}
+		existing, err := getSupply(stub, supply.Asset)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if existing != nil || t.supplies[supply.Asset] != nil {
+			return errorResponse(ERR_ASSET_EXISTS, fmt.Sprintf(`Supply of asset already existed. (Asset: "%s")`, supply.Asset))
+		}
+		key, err = stub.CreateCompositeKey(INDEX_SUPPLY, []string{supply.Asset})
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		t.supplies[supply.Asset] = supply
+		value = supply
+	case DOC_ACCOUNT:
+		account, err := ParseAccount(line)
+		if err != nil {
+			return errorResponse(ERR_INVALID_ARGUMENT, err.Error())
+		}
+		existing, err := getAccount(stub, account.Name)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		if existing != nil || t.accounts[account.Name] != nil {
+			return errorResponse(ERR_ACCOUNT_EXISTS, fmt.Sprintf(`Account already existed. (Account: "%s")`, account.Name))
+		}
+		for assetCode, balance := range account.Balances {
+			asset, resp := t.asset(stub, assetCode)
+			if asset == nil {
+				return resp
+			}
+			err = balance.Normalize(asset.Decimals)
+			if err != nil {
+				return errorResponse(ERR_INVALID_AMOUNT, fmt.Sprintf(`Corrupted balance. (Account: "%s", Asset: "%s", cause: %s)`, account.Name, assetCode, err))
+			}
+			// holds are not exported, so a held amount could never be released
+			if balance.Held.Sign() != 0 {
+				return errorResponse(ERR_BALANCE_NOT_ZERO, fmt.Sprintf(`Open holds must be released or cancelled before export. (Account: "%s", asset: "%s", held: %s)`, account.Name, assetCode, balance.Held))
+			}
+		}
+		for _, alias := range account.Aliases {
+			holder, err := lookupAlias(stub, alias)
+			if err != nil {
+				return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Invalid alias. (alias: "%s", cause: %s)`, alias, err))
+			}
+			if holder == "" {
+				holder = t.aliases[alias]
+			}
+			if holder != "" {
+				return errorResponse(ERR_ALIAS_EXISTS, fmt.Sprintf(`Alias already taken. (alias: "%s", Account: "%s")`, alias, holder))
+			}
+			err = indexAlias(stub, alias, account.Name)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+			t.aliases[alias] = account.Name
+		}
+		if account.Owner.MSPID != "" {
+			err = stampAccountPolicy(stub, account.Name, account.Owner.MSPID)
+			if err != nil {
+				return errorResponse(ERR_LEDGER, err.Error())
+			}
+		}
+		err = indexOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		key, err = accountKey(stub, account.Name)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+		t.accounts[account.Name] = account
+		value = account
+	default:
+		return errorResponse(ERR_INVALID_ARGUMENT, fmt.Sprintf(`Unsupported document type. (actual: "%s")`, doc.DocType))
+	}
+
+	// written as exported, keeping the transactions which created and updated the document
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// asset - asset registered before or loaded by this page
+func (t *importBatch) asset(stub shim.ChaincodeStubInterface, assetCode string) (*Asset, pb.Response) {
+	if asset, ok := t.assets[assetCode]; ok {
+		return asset, shim.Success(nil)
+	}
+	return loadAsset(stub, assetCode)
+}
+
+// checkSupply - check that the balances of every asset, including those of this page, add up to its
+// circulating supply
+func (t *importBatch) checkSupply(stub shim.ChaincodeStubInterface) pb.Response {
+	totals, err := sumBalances(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	for _, account := range t.accounts {
+		for assetCode, balance := range account.Balances {
+			totals[assetCode] = balance.Amount.Add(totals[assetCode])
+		}
+	}
+
+	assets, err := listAssets(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	for _, asset := range t.assets {
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Code < assets[j].Code })
+
+	for _, asset := range assets {
+		supply, ok := t.supplies[asset.Code]
+		if !ok {
+			var resp pb.Response
+			supply, resp = loadSupply(stub, asset)
+			if supply == nil {
+				return resp
+			}
+		}
+		total, ok := totals[asset.Code]
+		if !ok {
+			total = ZeroAmount(asset.Decimals)
+		}
+		circulating := supply.Circulating()
+		if total.Add(supply.ShieldedAmount()).Cmp(circulating) != 0 {
+			return errorResponse(ERR_SUPPLY_MISMATCH, fmt.Sprintf(`Imported balances do not add up to circulating supply. (Asset: "%s", circulating: %s, balances: %s)`, asset.Code, circulating, total))
+		}
+	}
+	return shim.Success(nil)
+}
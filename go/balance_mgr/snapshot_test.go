@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExportChecksum(t *testing.T) {
+	data := strings.Join([]string{`{"doc_type":"ASSET"}`, `{"doc_type":"SUPPLY"}`}, "\n")
+	checksum := exportChecksum(data)
+	assert.Equal(t, 64, len(checksum))
+	assert.Equal(t, checksum, exportChecksum(data))
+	assert.NotEqual(t, checksum, exportChecksum(data+"\n"))
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", exportChecksum(""))
+}
+
+func Test_ExportAccountLine(t *testing.T) {
+	account := NewAccount("a", Identity{MSPID: "Org1MSP", ID: "user1"})
+	amount, _ := ParseAmount("3.25", 2)
+	account.OpenBalance("PTS", 2).Amount = amount
+
+	// an exported line parses back to the same account
+	line, err := json.Marshal(account)
+	assert.Nil(t, err)
+	parsed, err := ParseAccount(line)
+	assert.Nil(t, err)
+	assert.Equal(t, account.Name, parsed.Name)
+	assert.Equal(t, "3.25", parsed.GetBalance("PTS").Amount.String())
+
+	reexported, _ := json.Marshal(parsed)
+	assert.Equal(t, string(line), string(reexported))
+}
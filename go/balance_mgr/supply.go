@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AssetConfig - asset registered by deployment argument
+type AssetConfig struct {
+	Code      string `json:"code"`
+	Decimals  int    `json:"decimals"`
+	IssuerMSP string `json:"issuer_msp"`
+	MaxSupply string `json:"max_supply,omitempty"`
+}
+
+// SupplyReport - supply counter of asset compared with the sum of account balances and the
+// amount shielded into confidential balances
+type SupplyReport struct {
+	Asset       string `json:"asset"`
+	Circulating Amount `json:"circulating"`
+	Balances    Amount `json:"balances"`
+	Shielded    Amount `json:"shielded"`
+	Difference  Amount `json:"difference"`
+	Consistent  bool   `json:"consistent"`
+}
+
+// initAssets - register assets of the JSON list given at deployment, with their max supply
+func initAssets(stub shim.ChaincodeStubInterface, data string) (int, error) {
+	configs := make([]*AssetConfig, 0)
+	err := json.Unmarshal([]byte(data), &configs)
+	if err != nil {
+		return 0, fmt.Errorf("invalid asset config, expecting a JSON list. cause: (%s)", err)
+	}
+
+	for _, config := range configs {
+		if config.Code == "" || config.IssuerMSP == "" {
+			return 0, fmt.Errorf("asset code and issuer MSP id must not be empty")
+		}
+		if config.Decimals < 0 || config.Decimals > MAX_DECIMALS {
+			return 0, fmt.Errorf(`invalid decimals, expecting an integer between 0 and %d. (asset: "%s", actual: %d)`, MAX_DECIMALS, config.Code, config.Decimals)
+		}
+		maxSupply, err := parseMaxSupply(config.MaxSupply, config.Decimals)
+		if err != nil {
+			return 0, err
+		}
+
+		asset, err := getAsset(stub, config.Code)
+		if err != nil {
+			return 0, err
+		}
+		if asset != nil {
+			return 0, fmt.Errorf(`asset already registered. (asset: "%s")`, config.Code)
+		}
+		err = putAsset(stub, NewAsset(config.Code, config.Decimals, config.IssuerMSP))
+		if err != nil {
+			return 0, err
+		}
+		err = putSupply(stub, NewSupply(config.Code, config.Decimals, maxSupply))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(configs), nil
+}
+
+// parseMaxSupply - parse non-negative max supply, empty value means unlimited
+func parseMaxSupply(val string, decimals int) (*Amount, error) {
+	if val == "" {
+		return nil, nil
+	}
+	maxSupply, err := ParseAmount(val, decimals)
+	if err != nil || maxSupply.Sign() < 0 {
+		return nil, fmt.Errorf(`Invalid max supply, expecting a non-negative value with at most %d decimals. (actual: "%s")`, decimals, val)
+	}
+	return &maxSupply, nil
+}
+
+// loadSupply - load supply of asset, the error response is returned if not found
+func loadSupply(stub shim.ChaincodeStubInterface, asset *Asset) (*Supply, pb.Response) {
+	supply, err := getSupply(stub, asset.Code)
+	if err != nil {
+		return nil, errorResponse(ERR_LEDGER, err.Error())
+	}
+	if supply == nil {
+		return nil, errorResponse(ERR_LEDGER, fmt.Sprintf(`Supply of asset not found, upgrade required. (Asset: "%s")`, asset.Code))
+	}
+	return supply, shim.Success(nil)
+}
+
+// mint: issue amount of asset into account (issuer only)
+func (t *BalanceManager) mint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("mint amount into account")
+	return t.issue(stub, args, ENTRY_MINT, EVENT_MINTED)
+}
+
+// issue - add amount to account and to issued supply of asset, journaled with entry type
+func (t *BalanceManager) issue(stub shim.ChaincodeStubInterface, args []string, entryType EntryType, eventType EventType) pb.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3 or 4")
+	}
+
+	if !isMinter(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin or minter is allowed to issue amount.")
+	}
+
+	accountName := args[0]
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	err := authorizeIssuer(stub, asset)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, err.Error())
+	}
+
+	cache := newAccountCache(stub)
+	account, balance, resp := cachedBalance(cache, accountName, asset)
+	if account == nil {
+		return resp
+	}
+	if resp, inactive := notActive(account); inactive {
+		return resp
+	}
+
+	amount, err := parseAmount(args[2], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, asset, account)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	supply, resp := loadSupply(stub, asset)
+	if supply == nil {
+		return resp
+	}
+	if !supply.CanIssue(amount) {
+		return errorResponse(ERR_SUPPLY_EXCEEDED, fmt.Sprintf(`Max supply exceeded. (Asset: "%s", circulating: %s, max supply: %s, amount: %s)`,
+			asset.Code, supply.Circulating(), supply.MaxSupply, amount))
+	}
+
+	balance.Amount = balance.Amount.Add(amount)
+	supply.Issued = supply.Issued.Add(amount)
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = putSupply(stub, supply)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	memo := optionalArg(args, 3)
+	err = journal.Append(entryType, accountName, asset.Code, "", amount, balance.Amount, memo)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, eventType, AmountEvent{Account: accountName, Asset: asset.Code, Amount: amount, Balance: balance.Amount, Memo: memo})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// burn: destroy amount of asset from account (issuer only). The account must be owned by the creator
+// unless admin, and only the available amount can be burned.
+func (t *BalanceManager) burn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("burn amount of account")
+
+	if len(args) != 3 && len(args) != 4 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 3 or 4")
+	}
+
+	if !isMinter(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin or minter is allowed to burn amount.")
+	}
+
+	accountName := args[0]
+	asset, resp := loadAsset(stub, args[1])
+	if asset == nil {
+		return resp
+	}
+	err := authorizeIssuer(stub, asset)
+	if err != nil {
+		return errorResponse(ERR_ACCESS_DENIED, err.Error())
+	}
+
+	cache := newAccountCache(stub)
+	account, balance, resp := cachedBalance(cache, accountName, asset)
+	if account == nil {
+		return resp
+	}
+	if !isAdmin(stub) {
+		err = authorizeOwner(stub, account)
+		if err != nil {
+			return errorResponse(ERR_ACCESS_DENIED, err.Error())
+		}
+	}
+	if resp, inactive := notActive(account); inactive {
+		return resp
+	}
+
+	amount, err := parseAmount(args[2], asset)
+	if err != nil {
+		return errorResponse(ERR_INVALID_AMOUNT, err.Error())
+	}
+	journal, err := newJournal(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	resp = accrueTouched(stub, cache, journal, asset, account)
+	if resp.Status != shim.OK {
+		return resp
+	}
+	// burning must not draw on credit, the circulating supply would fall below the balances
+	if balance.Available().Cmp(amount) < 0 {
+		return insufficientFunds(accountName, asset.Code, balance, amount)
+	}
+	supply, resp := loadSupply(stub, asset)
+	if supply == nil {
+		return resp
+	}
+
+	balance.Amount = balance.Amount.Sub(amount)
+	supply.Burned = supply.Burned.Add(amount)
+
+	err = cache.flush()
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+	err = putSupply(stub, supply)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	memo := optionalArg(args, 3)
+	err = journal.Append(ENTRY_BURN, accountName, asset.Code, "", amount.Neg(), balance.Amount, memo)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	err = emitEvent(stub, EVENT_BURNED, AmountEvent{Account: accountName, Asset: asset.Code, Amount: amount, Balance: balance.Amount, Memo: memo})
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// querySupply: query issued, burned and circulating amounts of asset
+func (t *BalanceManager) querySupply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 1")
+	}
+
+	asset, resp := loadAsset(stub, args[0])
+	if asset == nil {
+		return resp
+	}
+	supply, resp := loadSupply(stub, asset)
+	if supply == nil {
+		return resp
+	}
+
+	bytes, err := json.Marshal(NewSupplyView(supply))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
+
+// verifySupply: recompute the sum of all account balances per asset and compare it with the
+// circulating supply (admin only). Every registered asset is reported unless one is given.
+func (t *BalanceManager) verifySupply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return errorResponse(ERR_INVALID_ARGUMENT, "Incorrect number of arguments. Expecting 0 or 1")
+	}
+
+	if !isAdmin(stub) {
+		return errorResponse(ERR_ACCESS_DENIED, "Only admin is allowed to verify supply.")
+	}
+
+	var assets []*Asset
+	if len(args) == 1 {
+		asset, resp := loadAsset(stub, args[0])
+		if asset == nil {
+			return resp
+		}
+		assets = []*Asset{asset}
+	} else {
+		var err error
+		assets, err = listAssets(stub)
+		if err != nil {
+			return errorResponse(ERR_LEDGER, err.Error())
+		}
+	}
+
+	totals, err := sumBalances(stub)
+	if err != nil {
+		return errorResponse(ERR_LEDGER, err.Error())
+	}
+
+	reports := make([]*SupplyReport, 0, len(assets))
+	for _, asset := range assets {
+		supply, resp := loadSupply(stub, asset)
+		if supply == nil {
+			return resp
+		}
+		total, ok := totals[asset.Code]
+		if !ok {
+			total = ZeroAmount(asset.Decimals)
+		}
+		report := SupplyReport{Asset: asset.Code, Circulating: supply.Circulating(), Balances: total, Shielded: supply.ShieldedAmount()}
+		report.Difference = total.Add(report.Shielded).Sub(report.Circulating)
+		report.Consistent = report.Difference.Sign() == 0
+		if !report.Consistent {
+			fmt.Printf(`Supply mismatch. (asset: "%s", circulating: %s, balances: %s)`, asset.Code, report.Circulating, total)
+			fmt.Println()
+		}
+		reports = append(reports, &report)
+	}
+
+	bytes, err := json.Marshal(reports)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(bytes)
+}
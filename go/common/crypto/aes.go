@@ -29,17 +29,27 @@ func NewAESHelper(key string) (*AESHelper, error) {
 	return xaes, nil
 }
 
-// Encrypt - encrypt data
+// Encrypt - encrypt data with a random iv
 func (xaes *AESHelper) Encrypt(data string) (string, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	return xaes.EncryptWithIV(data, iv)
+}
+
+// EncryptWithIV - encrypt data with the given iv, e.g. derived identically by every endorser.
+// The iv must never be reused with the same key.
+func (xaes *AESHelper) EncryptWithIV(data string, iv []byte) (string, error) {
+	if len(iv) != aes.BlockSize {
+		return "", errors.New("iv length not supported. (only 16 is supported)")
+	}
 	block, err := aes.NewCipher(xaes.key)
 	if err != nil {
 		return "", err
 	}
 	ciphertext := make([]byte, aes.BlockSize+len(data))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return "", err
-	}
+	copy(ciphertext[:aes.BlockSize], iv)
 	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext[aes.BlockSize:],
 		[]byte(data))
 	return hex.EncodeToString(ciphertext), nil
@@ -137,3 +137,26 @@ func Test_AESDecryt(t *testing.T) {
 	t.Log(err)
 	assert.Equal(t, err, nil, "err should be nil.")
 }
+
+func Test_AESEncrytWithIV(t *testing.T) {
+	xaes, _ := NewAESHelper(KEY_AES_256)
+	iv := []byte(KEY_AES_128)
+
+	encoded, err := xaes.EncryptWithIV(STR_NORMAL, iv)
+	t.Log("check encryption with fixed iv.")
+	t.Logf(`encoded data is : %s`, encoded)
+	assert.Nil(t, err, "err should be nil.")
+
+	again, _ := xaes.EncryptWithIV(STR_NORMAL, iv)
+	t.Log("check same iv gives same result.")
+	assert.Equal(t, encoded, again, ``)
+
+	decoded, err := xaes.Decrypt(encoded)
+	assert.Equalf(t, decoded, STR_NORMAL, `decoded data should be '%s'`, STR_NORMAL)
+	assert.Nil(t, err, "err should be nil.")
+
+	encoded, err = xaes.EncryptWithIV(STR_NORMAL, []byte("123"))
+	t.Log("check iv length is not 16.")
+	assert.Empty(t, encoded, ``)
+	assert.NotNil(t, err, "err should not be nil.")
+}